@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/zlib"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"math"
 
 	"github.com/pierrec/lz4"
 	"github.com/pkg/errors"
@@ -15,6 +17,10 @@ import (
 
 var blockMagic = [4]byte{0xd3, 0x42, 0x4c, 0x4b}
 
+// blockHeaderSize is the size of the block header defined in the 1.x standard, excluding
+// the magic and the header size itself.
+const blockHeaderSize = 48
+
 // CompressionKind indicates the block compression type: none, zlib, bzip2 or lz4.
 type CompressionKind int
 
@@ -28,7 +34,8 @@ const (
 	// CompressionLZ4 corresponds to lz4 compression: very fast compression/decompression, poor compression ratio for complex data, moderate/good for ordered.
 	CompressionLZ4 CompressionKind = iota
 
-	// FlagStreamed denotes a streamed block. Not used anywhere yet.
+	// FlagStreamed denotes a streamed block. It is the last block in the file, and its payload
+	// extends to the end of the file.
 	FlagStreamed uint32 = 1
 )
 
@@ -43,6 +50,8 @@ type Block struct {
 
 	// checksum is MD5 of uncompressed `Data`.
 	checksum []byte
+	// dataSize is the size of uncompressed `Data`.
+	dataSize uint64
 }
 
 var compressionMapping = map[string]CompressionKind{
@@ -52,6 +61,13 @@ var compressionMapping = map[string]CompressionKind{
 	"lz4\x00":          CompressionLZ4,
 }
 
+var compressionCodes = map[CompressionKind]string{
+	CompressionNone:  "\x00\x00\x00\x00",
+	CompressionZLIB:  "zlib",
+	CompressionBZIP2: "bzp2",
+	CompressionLZ4:   "lz4\x00",
+}
+
 var compressionNames = map[CompressionKind]string{
 	CompressionNone:  "none",
 	CompressionZLIB:  "zlib",
@@ -66,25 +82,112 @@ var decompressors = map[CompressionKind]func(reader io.Reader) (io.Reader, error
 	CompressionLZ4:   newLZ4Reader,
 }
 
+var compressors = map[CompressionKind]func(data []byte) ([]byte, error){
+	CompressionZLIB:  compressZlib,
+	CompressionBZIP2: compressBzip2Block,
+	CompressionLZ4:   compressLZ4,
+}
+
+// Compress switches the block's compression from "none" to `kind`, compressing `Data` in-place.
+// The checksum of the uncompressed data is recorded.
+func (block *Block) Compress(kind CompressionKind) error {
+	if block.Compression != CompressionNone {
+		return errors.Errorf("the block is already compressed with %s",
+			compressionNames[block.Compression])
+	}
+	if kind == CompressionNone {
+		return nil
+	}
+	compressor, exists := compressors[kind]
+	if !exists {
+		return errors.Errorf("unsupported block compression: %d", kind)
+	}
+	checksum := md5.Sum(block.Data)
+	data, err := compressor(block.Data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to compress %d bytes with %s",
+			len(block.Data), compressionNames[kind])
+	}
+	block.checksum = checksum[:]
+	block.dataSize = uint64(len(block.Data))
+	block.Data = data
+	block.Compression = kind
+	return nil
+}
+
 // Uncompress switches the block's compression to "none", uncompressing `Data` in-place as needed
 // and checking the checksum.
 func (block *Block) Uncompress() error {
-	reader, err := decompressors[block.Compression](bytes.NewBuffer(block.Data))
-	if err != nil {
-		return errors.Wrapf(err, "failed to decompress %d bytes with %s",
-			len(block.Data), compressionNames[block.Compression])
+	return block.uncompress(context.Background(), blockOptions{})
+}
+
+// blockOptions tune reading and uncompressing the blocks.
+type blockOptions struct {
+	// maxSize is the maximum size of the block's payload, both compressed and uncompressed.
+	// Zero means no limit.
+	maxSize uint64
+	// skipChecksums disables verifying the MD5 checksums.
+	skipChecksums bool
+}
+
+// checkSize returns an error if the payload of the specified size exceeds the limit.
+func (options blockOptions) checkSize(size uint64) error {
+	if options.maxSize > 0 && size > options.maxSize {
+		return errors.Errorf("the block is too big: %d > %d", size, options.maxSize)
 	}
-	data, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return errors.Wrapf(err, "failed to decompress %d bytes with %s",
-			len(block.Data), compressionNames[block.Compression])
+	return nil
+}
+
+// checksumChunkSize is the amount of data hashed between checking the context.
+const checksumChunkSize = 1 << 24
+
+// uncompress is the same as Uncompress, but returns early if the context is done.
+func (block *Block) uncompress(ctx context.Context, options blockOptions) error {
+	if block.Compression != CompressionNone {
+		if err := options.checkSize(block.dataSize); err != nil {
+			return err
+		}
+		reader, err := decompressors[block.Compression](
+			contextReader{ctx: ctx, reader: bytes.NewBuffer(block.Data)})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to decompress %d bytes with %s",
+				len(block.Data), compressionNames[block.Compression])
+		}
+		if options.maxSize > 0 {
+			// the declared data size may lie
+			reader = io.LimitReader(reader, int64(options.maxSize)+1)
+		}
+		data, err := ioutil.ReadAll(contextReader{ctx: ctx, reader: reader})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = options.checkSize(uint64(len(data)))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to decompress %d bytes with %s",
+				len(block.Data), compressionNames[block.Compression])
+		}
+		block.Data = data
+		block.Compression = CompressionNone
 	}
-	block.Data = data
-	block.Compression = CompressionNone
-	if !bytes.Equal(block.checksum, bytes.Repeat([]byte{0}, 16)) {
+	block.dataSize = uint64(len(block.Data))
+	if !options.skipChecksums && !bytes.Equal(block.checksum, bytes.Repeat([]byte{0}, 16)) {
 		// check the checksum
 		hash := md5.New()
-		hash.Write(block.Data)
+		for offset := 0; offset < len(block.Data); offset += checksumChunkSize {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			end := offset + checksumChunkSize
+			if end > len(block.Data) {
+				end = len(block.Data)
+			}
+			hash.Write(block.Data[offset:end])
+		}
 		if !bytes.Equal(hash.Sum(nil), block.checksum) {
 			return errors.Errorf("block checksum mismatch: actual %v vs declared %v",
 				hash.Sum(nil), block.checksum)
@@ -93,28 +196,105 @@ func (block *Block) Uncompress() error {
 	return nil
 }
 
+// contextReader fails reading after the context is done.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.reader.Read(p)
+}
+
 // ReadBlock loads another block from the specified reader. That block may be compressed,
 // call `Uncompress()` to obtain the original Data.
 func ReadBlock(reader io.Reader) (*Block, error) {
+	return readBlock(reader, blockOptions{})
+}
+
+// readBlock is the same as ReadBlock, but refuses to read the payloads which are too big.
+func readBlock(reader io.Reader, options blockOptions) (*Block, error) {
+	block, header, err := readBlockHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if block.Flags&FlagStreamed != 0 {
+		if options.maxSize > 0 {
+			reader = io.LimitReader(reader, int64(options.maxSize)+1)
+		}
+		block.Data, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the streamed block's payload")
+		}
+		if err = options.checkSize(uint64(len(block.Data))); err != nil {
+			return nil, err
+		}
+		return block, nil
+	}
+	// the used size does not exceed the allocated size
+	if err = options.checkSize(header.AllocatedSize); err != nil {
+		return nil, err
+	}
+	block.Data = make([]byte, header.UsedSize)
+	_, err = io.ReadFull(reader, block.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the block's payload")
+	}
+	padding := int64(header.AllocatedSize - header.UsedSize)
+	if _, err = io.CopyN(ioutil.Discard, reader, padding); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.Wrap(err, "failed to read the block's remainder")
+	}
+	return block, nil
+}
+
+// blockHeader contains the block sizes which are not stored in `Block`.
+type blockHeader struct {
+	// Size is the header size, excluding the magic and the header size itself.
+	Size uint16
+	// AllocatedSize is the amount of space reserved for the payload.
+	AllocatedSize uint64
+	// UsedSize is the payload size.
+	UsedSize uint64
+}
+
+// Span returns the total number of bytes occupied by the block.
+func (header blockHeader) Span() int64 {
+	return int64(len(blockMagic)) + 2 + int64(header.Size) + int64(header.AllocatedSize)
+}
+
+// readBlockHeader loads the block's header and leaves the reader at the beginning of the payload.
+// `Block.Data` is not set.
+func readBlockHeader(reader io.Reader) (*Block, blockHeader, error) {
 	block := &Block{}
+	header := blockHeader{}
 	buffer := make([]byte, 4)
 	_, err := io.ReadFull(reader, buffer)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the block's magic")
+		return nil, header, errors.Wrap(err, "failed to read the block's magic")
 	}
 	if !bytes.Equal(buffer, blockMagic[:]) {
-		return nil, errors.Errorf("block magic does not match: %v", buffer)
+		return nil, header, errors.Errorf("block magic does not match: %v", buffer)
 	}
 	buffer = buffer[:2]
 	_, err = io.ReadFull(reader, buffer)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the block's header size")
+		return nil, header, errors.Wrap(err, "failed to read the block's header size")
+	}
+	header.Size = binary.BigEndian.Uint16(buffer)
+	if header.Size < blockHeaderSize {
+		return nil, header, errors.Errorf("block header is too small: %d < %d",
+			header.Size, blockHeaderSize)
 	}
-	headerSize := binary.BigEndian.Uint16(buffer)
-	buffer = make([]byte, headerSize)
+	buffer = make([]byte, header.Size)
 	_, err = io.ReadFull(reader, buffer)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the block's header")
+		return nil, header, errors.Wrap(err, "failed to read the block's header")
 	}
 	offset := 0
 	block.Flags = binary.BigEndian.Uint32(buffer[:4])
@@ -124,25 +304,72 @@ func ReadBlock(reader io.Reader) (*Block, error) {
 	var exists bool
 	block.Compression, exists = compressionMapping[string(compression)]
 	if !exists {
-		return nil, errors.Errorf("unsupported block compression: %s", string(compression))
+		return nil, header, errors.Errorf("unsupported block compression: %s", string(compression))
 	}
-	allocatedSize := binary.BigEndian.Uint64(buffer[offset : offset+8])
+	if block.Flags&FlagStreamed != 0 && block.Compression != CompressionNone {
+		return nil, header, errors.Errorf("streamed block may not be compressed with %s",
+			compressionNames[block.Compression])
+	}
+	header.AllocatedSize = binary.BigEndian.Uint64(buffer[offset : offset+8])
+	offset += 8
+	header.UsedSize = binary.BigEndian.Uint64(buffer[offset : offset+8])
+	offset += 8
+	// Span() must not overflow
+	if header.AllocatedSize > math.MaxInt64-uint64(len(blockMagic))-2-uint64(header.Size) {
+		return nil, header, errors.Errorf("block allocated size is too big: %d",
+			header.AllocatedSize)
+	}
+	if header.UsedSize > header.AllocatedSize {
+		return nil, header, errors.Errorf("block used size is greater than allocated: %d > %d",
+			header.UsedSize, header.AllocatedSize)
+	}
+	block.dataSize = binary.BigEndian.Uint64(buffer[offset : offset+8])
 	offset += 8
-	usedSize := binary.BigEndian.Uint64(buffer[offset : offset+8])
-	// ignore data_size
-	offset += 16
 	block.checksum = buffer[offset : offset+16]
-	block.Data = make([]byte, usedSize)
-	_, err = io.ReadFull(reader, block.Data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the block's payload")
+	return block, header, nil
+}
+
+// WriteBlock serializes the block to the specified writer. The block is written as-is,
+// call `Compress()` beforehand to change the compression. The sizes and the checksum of
+// a streamed block are zeros, so that more data can be appended to it later.
+func WriteBlock(writer io.Writer, block *Block) error {
+	streamed := block.Flags&FlagStreamed != 0
+	if streamed && block.Compression != CompressionNone {
+		return errors.Errorf("streamed block may not be compressed with %s",
+			compressionNames[block.Compression])
 	}
-	sink := make([]byte, allocatedSize-usedSize)
-	_, err = io.ReadFull(reader, sink)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read the block's remainder")
+	header := make([]byte, 4+2+blockHeaderSize)
+	copy(header, blockMagic[:])
+	binary.BigEndian.PutUint16(header[4:], blockHeaderSize)
+	binary.BigEndian.PutUint32(header[6:], block.Flags)
+	code, exists := compressionCodes[block.Compression]
+	if !exists {
+		return errors.Errorf("unsupported block compression: %d", block.Compression)
 	}
-	return block, nil
+	copy(header[10:], code)
+	if !streamed {
+		// allocated_size, used_size, data_size
+		binary.BigEndian.PutUint64(header[14:], uint64(len(block.Data)))
+		binary.BigEndian.PutUint64(header[22:], uint64(len(block.Data)))
+		dataSize := block.dataSize
+		if block.Compression == CompressionNone {
+			dataSize = uint64(len(block.Data))
+		}
+		binary.BigEndian.PutUint64(header[30:], dataSize)
+		if block.checksum != nil {
+			copy(header[38:], block.checksum)
+		} else if block.Compression == CompressionNone {
+			checksum := md5.Sum(block.Data)
+			copy(header[38:], checksum[:])
+		}
+	}
+	if _, err := writer.Write(header); err != nil {
+		return errors.Wrap(err, "failed to write the block's header")
+	}
+	if _, err := writer.Write(block.Data); err != nil {
+		return errors.Wrap(err, "failed to write the block's payload")
+	}
+	return nil
 }
 
 func newNoneReader(reader io.Reader) (io.Reader, error) {
@@ -194,3 +421,71 @@ func newLZ4Reader(reader io.Reader) (io.Reader, error) {
 	}
 	return bytes.NewReader(writer.Bytes()), nil
 }
+
+func compressZlib(data []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	writer := zlib.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func compressBzip2Block(data []byte) ([]byte, error) {
+	return compressBzip2(data), nil
+}
+
+// lz4ChunkSize is the maximum size of the uncompressed data in each LZ4 block.
+const lz4ChunkSize = 1 << 22
+
+func compressLZ4(data []byte) ([]byte, error) {
+	// The same format as read by newLZ4Reader().
+	buffer := &bytes.Buffer{}
+	hashTable := make([]int, 1<<16)
+	header := make([]byte, 8)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > lz4ChunkSize {
+			chunk = chunk[:lz4ChunkSize]
+		}
+		data = data[len(chunk):]
+		compressed := make([]byte, lz4.CompressBlockBound(len(chunk)))
+		for i := range hashTable {
+			hashTable[i] = 0
+		}
+		n, err := lz4.CompressBlock(chunk, compressed, hashTable)
+		if err != nil {
+			return nil, errors.Wrap(err, "lz4 error")
+		}
+		if n == 0 {
+			// incompressible
+			compressed = storeLZ4Literals(chunk)
+		} else {
+			compressed = compressed[:n]
+		}
+		binary.BigEndian.PutUint32(header, uint32(len(compressed)+4))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(chunk)))
+		buffer.Write(header)
+		buffer.Write(compressed)
+	}
+	return buffer.Bytes(), nil
+}
+
+// storeLZ4Literals generates the LZ4 block which consists of a single literal run.
+func storeLZ4Literals(data []byte) []byte {
+	block := make([]byte, 0, lz4.CompressBlockBound(len(data)))
+	if len(data) < 15 {
+		block = append(block, byte(len(data)<<4))
+	} else {
+		block = append(block, 0xf0)
+		rest := len(data) - 15
+		for ; rest >= 255; rest -= 255 {
+			block = append(block, 255)
+		}
+		block = append(block, byte(rest))
+	}
+	return append(block, data...)
+}
@@ -0,0 +1,229 @@
+package asdf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+// blockReader provides random access to the binary blocks which follow the tree.
+// It is safe for concurrent use.
+type blockReader struct {
+	reader io.ReadSeeker
+	lock   sync.Mutex
+	// offsets are the positions of the blocks discovered so far.
+	offsets []int64
+	options blockOptions
+}
+
+func newBlockReader(reader io.ReadSeeker, firstBlockOffset int64, options blockOptions) *blockReader {
+	return &blockReader{reader: reader, offsets: []int64{firstBlockOffset}, options: options}
+}
+
+// ReadIndex loads the block index from the end of the file. The index allows to seek to any block
+// without scanning the preceding ones. Missing or inconsistent index is ignored, and the blocks
+// are scanned sequentially as usual. Only I/O errors are returned.
+func (br *blockReader) ReadIndex() error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	size, err := br.reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	indexPos, index, err := findBlockIndex(br.reader, br.offsets[0], size)
+	if err != nil || index == nil {
+		return err
+	}
+	var offsets []int64
+	if yaml.Unmarshal(index, &offsets) != nil || len(offsets) == 0 || offsets[0] != br.offsets[0] {
+		return nil
+	}
+	for i, offset := range offsets {
+		if i > 0 && offset <= offsets[i-1] {
+			return nil
+		}
+		if _, err = br.reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, header, err := readBlockHeader(br.reader)
+		if err != nil {
+			// not a block
+			return nil
+		}
+		if i < len(offsets)-1 && offset+header.Span() > offsets[i+1] ||
+			i == len(offsets)-1 && offset+header.Span() > indexPos {
+			return nil
+		}
+	}
+	br.offsets = offsets
+	return nil
+}
+
+// findBlockIndex searches backwards for the block index header and returns its position
+// and the index document. The search stops at the first byte which cannot belong to YAML.
+func findBlockIndex(reader io.ReadSeeker, start, end int64) (int64, []byte, error) {
+	header := []byte(blockIndexHeader)
+	var tail []byte
+	for pos := end; pos > start; {
+		chunkSize := int64(bufferSize)
+		if pos-start < chunkSize {
+			chunkSize = pos - start
+		}
+		pos -= chunkSize
+		chunk := make([]byte, chunkSize, chunkSize+int64(len(tail)))
+		if _, err := reader.Seek(pos, io.SeekStart); err != nil {
+			return -1, nil, err
+		}
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return -1, nil, err
+		}
+		tail = append(chunk, tail...)
+		if found := bytes.LastIndex(tail, header); found >= 0 {
+			return pos + int64(found), tail[found:], nil
+		}
+		for _, c := range chunk {
+			if (c < ' ' || c > '~') && c != '\n' && c != '\r' && c != '\t' {
+				return -1, nil, nil
+			}
+		}
+	}
+	return -1, nil, nil
+}
+
+// ReadBlock loads the block with the specified index. Unless the block index was read, the
+// preceding blocks' headers are scanned to find its position, their payloads are skipped.
+func (br *blockReader) ReadBlock(index int) (*Block, error) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if err := br.locate(index); err != nil {
+		return nil, err
+	}
+	if mapping, ok := br.reader.(*mappedFile); ok {
+		return mapping.ReadBlock(br.offsets[index], br.options)
+	}
+	if _, err := br.reader.Seek(br.offsets[index], io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readBlock(br.reader, br.options)
+}
+
+// ReadSize returns the size of the block with the specified index according to its header.
+// The payload is not read.
+func (br *blockReader) ReadSize(index int) (blockSize, error) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if err := br.locate(index); err != nil {
+		return blockSize{}, err
+	}
+	if _, err := br.reader.Seek(br.offsets[index], io.SeekStart); err != nil {
+		return blockSize{}, err
+	}
+	block, header, err := readBlockHeader(br.reader)
+	if err != nil {
+		return blockSize{}, err
+	}
+	if block.Flags&FlagStreamed != 0 {
+		// the payload extends to the end of the file
+		start, err := br.reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return blockSize{}, err
+		}
+		end, err := br.reader.Seek(0, io.SeekEnd)
+		if err != nil {
+			return blockSize{}, err
+		}
+		return blockSize{stored: uint64(end - start), uncompressed: uint64(end - start)}, nil
+	}
+	size := blockSize{stored: header.UsedSize, uncompressed: block.dataSize}
+	if block.Compression == CompressionNone {
+		size.uncompressed = header.UsedSize
+	}
+	return size, nil
+}
+
+// locate scans the blocks' headers until the position of the block with the specified index
+// is discovered. The caller must hold the lock.
+func (br *blockReader) locate(index int) error {
+	for len(br.offsets) <= index {
+		last := br.offsets[len(br.offsets)-1]
+		if _, err := br.reader.Seek(last, io.SeekStart); err != nil {
+			return err
+		}
+		block, header, err := readBlockHeader(br.reader)
+		if err != nil {
+			return errors.Wrapf(err, "while scanning block #%d", len(br.offsets)-1)
+		}
+		if block.Flags&FlagStreamed != 0 {
+			return errors.Errorf("block #%d does not exist: block #%d is streamed",
+				index, len(br.offsets)-1)
+		}
+		if err = br.options.checkSize(header.AllocatedSize); err != nil {
+			return errors.Wrapf(err, "while scanning block #%d", len(br.offsets)-1)
+		}
+		br.offsets = append(br.offsets, last+header.Span())
+	}
+	return nil
+}
+
+// lazyBlocks loads each block at most once and shares it between the arrays.
+type lazyBlocks struct {
+	reader   *blockReader
+	resolver Resolver
+	options  blockOptions
+	lock     sync.Mutex
+	// blocks are indexed by the block number or by the external URI.
+	blocks map[interface{}]*lazyBlock
+}
+
+type lazyBlock struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// Loader returns the function which reads and uncompresses the block with the specified index.
+func (lb *lazyBlocks) Loader(index int) core.BlockLoader {
+	return lb.loader(index, func() ([]byte, error) {
+		if lb.reader == nil {
+			return nil, errors.Errorf("block #%d does not exist", index)
+		}
+		block, err := lb.reader.ReadBlock(index)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading block #%d", index)
+		}
+		if err = block.uncompress(context.Background(), lb.options); err != nil {
+			return nil, errors.Wrapf(err, "uncompressing block #%d", index)
+		}
+		return block.Data, nil
+	})
+}
+
+// ExternalLoader returns the function which reads and uncompresses the first block of
+// the referenced file.
+func (lb *lazyBlocks) ExternalLoader(uri string) core.BlockLoader {
+	return lb.loader(uri, func() ([]byte, error) {
+		return readExternalBlock(context.Background(), lb.resolver, uri, lb.options)
+	})
+}
+
+func (lb *lazyBlocks) loader(key interface{}, load func() ([]byte, error)) core.BlockLoader {
+	lb.lock.Lock()
+	block := lb.blocks[key]
+	if block == nil {
+		block = &lazyBlock{}
+		lb.blocks[key] = block
+	}
+	lb.lock.Unlock()
+	return func() ([]byte, error) {
+		block.once.Do(func() {
+			block.data, block.err = load()
+		})
+		return block.data, block.err
+	}
+}
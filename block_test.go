@@ -0,0 +1,146 @@
+package asdf
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func blockTestPayloads() map[string][]byte {
+	random := make([]byte, 1<<16)
+	rand.New(rand.NewSource(7)).Read(random)
+	ordered := make([]byte, 3<<20)
+	for i := range ordered {
+		ordered[i] = byte(i / 1000)
+	}
+	return map[string][]byte{
+		"empty":   {},
+		"one":     {42},
+		"text":    []byte("abracadabra abracadabra abracadabra"),
+		"runs":    append(bytes.Repeat([]byte{7}, 1000), bytes.Repeat([]byte{8}, 3)...),
+		"random":  random,
+		"ordered": ordered,
+	}
+}
+
+func TestBlockCompressRoundTrip(t *testing.T) {
+	req := require.New(t)
+	for name, payload := range blockTestPayloads() {
+		for _, kind := range []CompressionKind{
+			CompressionNone, CompressionZLIB, CompressionBZIP2, CompressionLZ4} {
+			block := &Block{Data: append([]byte{}, payload...)}
+			req.NoError(block.Compress(kind), "%s %s", name, compressionNames[kind])
+			req.Equal(kind, block.Compression)
+			buffer := &bytes.Buffer{}
+			req.NoError(WriteBlock(buffer, block), "%s %s", name, compressionNames[kind])
+			loaded, err := ReadBlock(buffer)
+			req.NoError(err, "%s %s", name, compressionNames[kind])
+			req.Equal(0, buffer.Len())
+			req.Equal(kind, loaded.Compression)
+			req.Equal(uint64(len(payload)), loaded.dataSize)
+			req.NoError(loaded.Uncompress(), "%s %s", name, compressionNames[kind])
+			req.Equal(payload, loaded.Data, "%s %s", name, compressionNames[kind])
+		}
+	}
+}
+
+func TestBlockCompressTwice(t *testing.T) {
+	req := require.New(t)
+	block := &Block{Data: []byte("data")}
+	req.NoError(block.Compress(CompressionZLIB))
+	req.Error(block.Compress(CompressionLZ4))
+	req.Error((&Block{}).Compress(CompressionKind(100)))
+}
+
+func TestCompressBzip2(t *testing.T) {
+	req := require.New(t)
+	payloads := blockTestPayloads()
+	// several bzip2 blocks
+	multi := make([]byte, 2*bzip2BlockSize)
+	random := rand.New(rand.NewSource(7))
+	for i := range multi {
+		multi[i] = byte(random.Intn(4))
+	}
+	payloads["multi"] = multi
+	// the full 256-symbol alphabet over several blocks
+	noise := make([]byte, 2*bzip2BlockSize+12345)
+	random.Read(noise)
+	payloads["noise"] = noise
+	// the runs are shortened before the block is cut
+	payloads["constant"] = bytes.Repeat([]byte{0xaa}, 5*bzip2BlockSize)
+	alphabet := make([]byte, 256*4)
+	for i := range alphabet {
+		alphabet[i] = byte(i)
+	}
+	payloads["alphabet"] = alphabet
+	payloads["alphabet cycles"] = bytes.Repeat(alphabet, bzip2BlockSize/len(alphabet)+7)
+	for name, payload := range payloads {
+		data, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(compressBzip2(payload))))
+		req.NoError(err, name)
+		req.Equal(len(payload), len(data), name)
+		req.Equal(payload, data, name)
+	}
+}
+
+// countdownContext is done after its Err() has been called the specified number of times.
+type countdownContext struct {
+	context.Context
+	calls int
+}
+
+func (cc *countdownContext) Err() error {
+	if cc.calls <= 0 {
+		return context.Canceled
+	}
+	cc.calls--
+	return nil
+}
+
+func TestBlockUncompressContext(t *testing.T) {
+	req := require.New(t)
+	payload := bytes.Repeat([]byte("asdf"), 1<<20)
+	for _, kind := range []CompressionKind{CompressionZLIB, CompressionBZIP2, CompressionLZ4} {
+		block := &Block{Data: payload}
+		req.NoError(block.Compress(kind))
+		compressed := block.Data
+		req.NoError(block.uncompress(&countdownContext{calls: 1 << 30}, blockOptions{}))
+		req.Equal(payload, block.Data)
+		for _, calls := range []int{0, 1, 5} {
+			block = &Block{Data: compressed, Compression: kind, checksum: block.checksum}
+			err := block.uncompress(&countdownContext{calls: calls}, blockOptions{})
+			req.Equal(context.Canceled, err, compressionNames[kind])
+			req.Equal(kind, block.Compression)
+		}
+	}
+}
+
+func TestReadBlockHugeAllocatedSize(t *testing.T) {
+	req := require.New(t)
+	buffer := &bytes.Buffer{}
+	req.NoError(WriteBlock(buffer, &Block{Data: []byte("data")}))
+	contents := buffer.Bytes()
+	// allocated_size
+	binary.BigEndian.PutUint64(contents[14:], 1<<62)
+	_, err := readBlock(bytes.NewReader(contents), blockOptions{maxSize: 100})
+	req.EqualError(err, "the block is too big: 4611686018427387904 > 100")
+	blocks := newBlockReader(bytes.NewReader(append(contents, contents...)), 0,
+		blockOptions{maxSize: 100})
+	_, err = blocks.ReadBlock(1)
+	req.Error(err)
+	req.Contains(err.Error(), "too big")
+	// the padding is skipped rather than allocated
+	_, err = readBlock(bytes.NewReader(contents), blockOptions{})
+	req.Equal(io.ErrUnexpectedEOF, errors.Cause(err))
+	binary.BigEndian.PutUint64(contents[14:], math.MaxUint64)
+	_, err = ReadBlock(bytes.NewReader(contents))
+	req.EqualError(err, "block allocated size is too big: 18446744073709551615")
+}
@@ -0,0 +1,364 @@
+package asdf
+
+import (
+	"bytes"
+	"sort"
+)
+
+// The standard library provides only the bzip2 decompressor, so we implement a simple
+// single-table encoder here. It follows the reference bzip2 implementation in everything
+// except the Huffman coding: all the 50-symbol groups share the same table.
+
+const (
+	// bzip2BlockSize is the maximum size of the run-length encoded block at level 9.
+	bzip2BlockSize = 900000 - 19
+	// bzip2MaxCodeLength is the maximum Huffman code length emitted by the reference encoder.
+	bzip2MaxCodeLength = 17
+	// bzip2GroupSize is the number of symbols coded with the same Huffman table.
+	bzip2GroupSize = 50
+	bzip2RunA      = 0
+	bzip2RunB      = 1
+)
+
+var bzip2CRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// bzip2BitWriter packs bits MSB-first.
+type bzip2BitWriter struct {
+	buffer bytes.Buffer
+	bits   uint64
+	count  uint
+}
+
+func (bw *bzip2BitWriter) WriteBits(value uint64, count uint) {
+	for count > 0 {
+		take := count
+		if take > 32 {
+			take = 32
+		}
+		count -= take
+		bw.bits = bw.bits<<take | (value>>count)&(1<<take-1)
+		bw.count += take
+		for bw.count >= 8 {
+			bw.count -= 8
+			bw.buffer.WriteByte(byte(bw.bits >> bw.count))
+		}
+	}
+}
+
+func (bw *bzip2BitWriter) Flush() []byte {
+	if bw.count > 0 {
+		bw.buffer.WriteByte(byte(bw.bits << (8 - bw.count)))
+		bw.count = 0
+	}
+	return bw.buffer.Bytes()
+}
+
+// compressBzip2 compresses the whole `data` into a bzip2 stream with 900k blocks.
+func compressBzip2(data []byte) []byte {
+	bw := &bzip2BitWriter{}
+	bw.WriteBits('B', 8)
+	bw.WriteBits('Z', 8)
+	bw.WriteBits('h', 8)
+	bw.WriteBits('9', 8)
+	var combinedCRC uint32
+	for len(data) > 0 {
+		block, consumed := bzip2RunLengthEncode(data)
+		crc := uint32(0xffffffff)
+		for _, b := range data[:consumed] {
+			crc = crc<<8 ^ bzip2CRCTable[byte(crc>>24)^b]
+		}
+		crc = ^crc
+		combinedCRC = (combinedCRC<<1 | combinedCRC>>31) ^ crc
+		data = data[consumed:]
+		bw.WriteBits(0x314159265359, 48)
+		bw.WriteBits(uint64(crc), 32)
+		// not randomized
+		bw.WriteBits(0, 1)
+		bzip2WriteBlock(bw, block)
+	}
+	bw.WriteBits(0x177245385090, 48)
+	bw.WriteBits(uint64(combinedCRC), 32)
+	return bw.Flush()
+}
+
+// bzip2RunLengthEncode applies the initial run-length encoding: runs of 4 to 255 equal bytes
+// are replaced with 4 bytes and the repetition count. It returns the encoded block and
+// the number of consumed input bytes.
+func bzip2RunLengthEncode(data []byte) ([]byte, int) {
+	block := make([]byte, 0, bzip2BlockSize)
+	i := 0
+	for i < len(data) && len(block)+5 <= bzip2BlockSize {
+		run := 1
+		for i+run < len(data) && run < 255 && data[i+run] == data[i] {
+			run++
+		}
+		if run < 4 {
+			block = append(block, data[i:i+run]...)
+		} else {
+			block = append(block, data[i], data[i], data[i], data[i], byte(run-4))
+		}
+		i += run
+	}
+	return block, i
+}
+
+func bzip2WriteBlock(bw *bzip2BitWriter, block []byte) {
+	bwt, origPtr := bzip2Transform(block)
+	bw.WriteBits(uint64(origPtr), 24)
+
+	var inUse [256]bool
+	for _, b := range block {
+		inUse[b] = true
+	}
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				ranges |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	bw.WriteBits(ranges, 16)
+	var symbols []byte
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var bits uint64
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << uint(15-j)
+				symbols = append(symbols, byte(i*16+j))
+			}
+		}
+		bw.WriteBits(bits, 16)
+	}
+
+	codes := bzip2MoveToFront(bwt, symbols)
+	alphaSize := len(symbols) + 2
+	freqs := make([]int, alphaSize)
+	for _, code := range codes {
+		freqs[code]++
+	}
+	lengths := bzip2CodeLengths(freqs)
+
+	// The format requires at least two tables, both are the same.
+	const groups = 2
+	bw.WriteBits(groups, 3)
+	selectors := (len(codes) + bzip2GroupSize - 1) / bzip2GroupSize
+	bw.WriteBits(uint64(selectors), 15)
+	for i := 0; i < selectors; i++ {
+		// the MTF-encoded index of the first table
+		bw.WriteBits(0, 1)
+	}
+	for g := 0; g < groups; g++ {
+		current := lengths[0]
+		bw.WriteBits(uint64(current), 5)
+		for _, length := range lengths {
+			for current < length {
+				bw.WriteBits(2, 2)
+				current++
+			}
+			for current > length {
+				bw.WriteBits(3, 2)
+				current--
+			}
+			bw.WriteBits(0, 1)
+		}
+	}
+	table := bzip2AssignCodes(lengths)
+	for _, code := range codes {
+		bw.WriteBits(uint64(table[code]), uint(lengths[code]))
+	}
+}
+
+// bzip2Transform performs the Burrows-Wheeler transform. It returns the last column of the sorted
+// rotations and the position of the original string among them.
+func bzip2Transform(block []byte) ([]byte, int) {
+	n := len(block)
+	// prefix doubling over the cyclic shifts with counting sorts
+	perm := make([]int, n)
+	classes := make([]int, n)
+	counts := make([]int, n+256)
+	for _, b := range block {
+		counts[b]++
+	}
+	for i := 1; i < 256; i++ {
+		counts[i] += counts[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		counts[block[i]]--
+		perm[counts[block[i]]] = i
+	}
+	numClasses := 1
+	for i := 1; i < n; i++ {
+		if block[perm[i]] != block[perm[i-1]] {
+			numClasses++
+		}
+		classes[perm[i]] = numClasses - 1
+	}
+	nextPerm := make([]int, n)
+	nextClasses := make([]int, n)
+	for h := 1; h < n && numClasses < n; h <<= 1 {
+		for i, p := range perm {
+			nextPerm[i] = p - h
+			if nextPerm[i] < 0 {
+				nextPerm[i] += n
+			}
+		}
+		for i := 0; i < numClasses; i++ {
+			counts[i] = 0
+		}
+		for _, p := range nextPerm {
+			counts[classes[p]]++
+		}
+		for i := 1; i < numClasses; i++ {
+			counts[i] += counts[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			p := nextPerm[i]
+			counts[classes[p]]--
+			perm[counts[classes[p]]] = p
+		}
+		nextClasses[perm[0]] = 0
+		numClasses = 1
+		for i := 1; i < n; i++ {
+			cur, prev := perm[i], perm[i-1]
+			if classes[cur] != classes[prev] || classes[(cur+h)%n] != classes[(prev+h)%n] {
+				numClasses++
+			}
+			nextClasses[cur] = numClasses - 1
+		}
+		classes, nextClasses = nextClasses, classes
+	}
+	last := make([]byte, n)
+	origPtr := 0
+	for i, p := range perm {
+		if p == 0 {
+			origPtr = i
+			last[i] = block[n-1]
+		} else {
+			last[i] = block[p-1]
+		}
+	}
+	return last, origPtr
+}
+
+// bzip2MoveToFront applies the move-to-front transform followed by the zero run-length encoding.
+// The last symbol is the end of block.
+func bzip2MoveToFront(data []byte, symbols []byte) []uint16 {
+	order := make([]byte, len(symbols))
+	copy(order, symbols)
+	codes := make([]uint16, 0, len(data)+1)
+	zeros := 0
+	flushZeros := func() {
+		if zeros == 0 {
+			return
+		}
+		zeros--
+		for {
+			codes = append(codes, uint16(bzip2RunA+zeros&1))
+			if zeros < 2 {
+				break
+			}
+			zeros = (zeros - 2) / 2
+		}
+		zeros = 0
+	}
+	for _, b := range data {
+		j := bytes.IndexByte(order, b)
+		if j == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		copy(order[1:j+1], order[:j])
+		order[0] = b
+		codes = append(codes, uint16(j+1))
+	}
+	flushZeros()
+	return append(codes, uint16(len(symbols)+1))
+}
+
+// bzip2CodeLengths calculates the Huffman code lengths limited by bzip2MaxCodeLength.
+// Every symbol receives a code, even if it never occurs.
+func bzip2CodeLengths(freqs []int) []uint8 {
+	weights := make([]int, len(freqs))
+	for i, freq := range freqs {
+		weights[i] = freq
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+	for {
+		lengths := huffmanCodeLengths(weights)
+		fits := true
+		for _, length := range lengths {
+			if length > bzip2MaxCodeLength {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return lengths
+		}
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+func huffmanCodeLengths(weights []int) []uint8 {
+	type node struct {
+		weight int
+		leaves []int
+	}
+	nodes := make([]node, len(weights))
+	for i, weight := range weights {
+		nodes[i] = node{weight, []int{i}}
+	}
+	lengths := make([]uint8, len(weights))
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].weight < nodes[j].weight
+		})
+		merged := node{nodes[0].weight + nodes[1].weight,
+			append(append([]int{}, nodes[0].leaves...), nodes[1].leaves...)}
+		for _, leaf := range merged.leaves {
+			lengths[leaf]++
+		}
+		nodes = append(nodes[2:], merged)
+	}
+	return lengths
+}
+
+// bzip2AssignCodes builds the canonical Huffman codes from their lengths.
+func bzip2AssignCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	code := uint32(0)
+	for length := uint8(1); length <= bzip2MaxCodeLength; length++ {
+		for i, l := range lengths {
+			if l == length {
+				codes[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
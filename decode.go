@@ -0,0 +1,564 @@
+package asdf
+
+import (
+	"fmt"
+	"go/types"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/pkg/errors"
+
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+// Decode reads the ASDF file from the file system and maps its tree onto `v`, see File.Decode().
+// The blocks are copied from the file, so the decoded arrays stay valid.
+func Decode(fileName string, v interface{}) error {
+	file, err := OpenFile(fileName, nil)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Decode(v)
+}
+
+// Decode maps the tree onto `v`, which must be a non-nil pointer, similar to `json.Unmarshal`.
+// The struct fields are matched to the keys by the `asdf:"name"` tags, or by the field names
+// if there are no tags; the untagged fields fall back to the case-insensitive match, and the
+// first of several such keys in sorted order wins. "-" skips the field. The top level keys
+// "asdf_library" and "history" refer to `Library` and `History`, so they can be decoded into
+// `core.Software` and `core.History`. The arrays are decoded into `*core.NDArray` or into
+// numeric, boolean or string slices nested as many times as there are dimensions, e.g.
+// `[]float64` or `[][]string`; the numbers are converted to the slice's type unless they overflow
+// it or lose the fractional part. The lazily opened arrays are loaded as needed. The error
+// reports the path to the mismatched value, including the indexes of the array elements.
+func (file *File) Decode(v interface{}) error {
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.Errorf("cannot decode into %T: not a non-nil pointer", v)
+	}
+	return decodeValue(file.decodedRoot(), dst.Elem(), nil)
+}
+
+// DecodePath maps the subtree at the dot-separated `path` onto `v` the same way as Decode(),
+// e.g. `file.DecodePath("images.0.pixels", &pixels)`. The array indexes are numbers.
+func (file *File) DecodePath(path string, v interface{}) error {
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.Errorf("cannot decode into %T: not a non-nil pointer", v)
+	}
+	root := gabs.Wrap(file.decodedRoot())
+	if !root.ExistsP(path) {
+		return errors.Errorf("%s does not exist", path)
+	}
+	return decodeValue(root.Path(path).Data(), dst.Elem(), strings.Split(path, "."))
+}
+
+// decodedRoot returns the top level object with `Library` and `History` as the reserved keys.
+func (file *File) decodedRoot() map[string]interface{} {
+	root := map[string]interface{}{}
+	if file.Tree != nil {
+		if tree, ok := file.Tree.Data().(map[string]interface{}); ok {
+			for key, val := range tree {
+				root[key] = val
+			}
+		}
+	}
+	if file.Library != nil {
+		root["asdf_library"] = file.Library
+	}
+	if file.History != nil {
+		root["history"] = file.History
+	}
+	return root
+}
+
+func decodeValue(src interface{}, dst reflect.Value, path []string) error {
+	fail := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if len(path) == 0 {
+			return errors.New(msg)
+		}
+		return errors.Errorf("%s: %s", strings.Join(path, "."), msg)
+	}
+	mismatch := func() error {
+		return fail("cannot decode %s into %s", describeSource(src), dst.Type())
+	}
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	srcValue := reflect.ValueOf(src)
+	if srcValue.Type().AssignableTo(dst.Type()) {
+		dst.Set(srcValue)
+		return nil
+	}
+	if srcValue.Kind() == reflect.Ptr && srcValue.Type().Elem().AssignableTo(dst.Type()) {
+		dst.Set(srcValue.Elem())
+		return nil
+	}
+	if arr, ok := src.(*core.NDArray); ok && dst.Kind() == reflect.Slice {
+		if err := arr.Load(); err != nil {
+			return fail("%v", err)
+		}
+		return decodeArray(arr, dst, path)
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(src, elem.Elem(), path); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Struct:
+		object, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		return decodeStruct(object, dst, path)
+	case reflect.Map:
+		object, ok := src.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		result := reflect.MakeMapWithSize(dst.Type(), len(object))
+		for key, val := range object {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(val, elem, appendPath(path, key)); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(result)
+	case reflect.Slice:
+		list, ok := src.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		result := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, val := range list {
+			err := decodeValue(val, result.Index(i), appendPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return err
+			}
+		}
+		dst.Set(result)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var number int64
+		switch val := src.(type) {
+		case int:
+			number = int64(val)
+		case float64:
+			if val != math.Trunc(val) || val < math.MinInt64 || val >= math.MaxInt64 {
+				return mismatch()
+			}
+			number = int64(val)
+		default:
+			return mismatch()
+		}
+		if dst.OverflowInt(number) {
+			return fail("%d overflows %s", number, dst.Type())
+		}
+		dst.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var number uint64
+		switch val := src.(type) {
+		case int:
+			if val < 0 {
+				return fail("%d overflows %s", val, dst.Type())
+			}
+			number = uint64(val)
+		case float64:
+			if val != math.Trunc(val) || val < 0 || val >= math.MaxUint64 {
+				return mismatch()
+			}
+			number = uint64(val)
+		default:
+			return mismatch()
+		}
+		if dst.OverflowUint(number) {
+			return fail("%d overflows %s", number, dst.Type())
+		}
+		dst.SetUint(number)
+	case reflect.Float32, reflect.Float64:
+		switch val := src.(type) {
+		case int:
+			dst.SetFloat(float64(val))
+		case float64:
+			dst.SetFloat(val)
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		str, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(str)
+	case reflect.Bool:
+		flag, ok := src.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(flag)
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+func decodeStruct(object map[string]interface{}, dst reflect.Value, path []string) error {
+	for _, field := range structFields(dst.Type()) {
+		key := field.name
+		val, exists := object[key]
+		if !exists && !field.tagged {
+			// the exact match is preferred, then the first matching key in sorted order
+			var candidates []string
+			for candidate := range object {
+				if strings.EqualFold(candidate, field.name) {
+					candidates = append(candidates, candidate)
+				}
+			}
+			if len(candidates) > 0 {
+				sort.Strings(candidates)
+				key = candidates[0]
+				val, exists = object[key], true
+			}
+		}
+		if !exists {
+			continue
+		}
+		err := decodeValue(val, dst.FieldByIndex(field.index), appendPath(path, key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structField is the exported field of a struct which maps to a key in the tree.
+type structField struct {
+	// name is the key in the tree.
+	name string
+	// tagged indicates whether the name is set by the `asdf` tag.
+	tagged bool
+	// index is the sequence of indexes for reflect.Value.FieldByIndex().
+	index []int
+	// omitEmpty indicates whether the zero value is not written.
+	omitEmpty bool
+}
+
+// structFields lists the fields of the struct type. The fields of the embedded structs
+// without tags are included as if they belonged to the outer struct.
+func structFields(structType reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, tagged := field.Tag.Lookup("asdf")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && !tagged && field.Type.Kind() == reflect.Struct {
+			for _, inner := range structFields(field.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		options := strings.Split(tag, ",")
+		name := options[0]
+		if name == "" {
+			name = field.Name
+		}
+		omitEmpty := false
+		for _, option := range options[1:] {
+			if option == "omitempty" {
+				omitEmpty = true
+			}
+		}
+		fields = append(fields, structField{
+			name: name, tagged: options[0] != "", index: []int{i}, omitEmpty: omitEmpty})
+	}
+	return fields
+}
+
+// decodeArray converts the array elements to the nested slice `dst`. The elements which need
+// no conversion are copied in bulk, and the rows share one backing slice. Otherwise, the numbers
+// are checked for overflow and truncation the same way as the scalars, and the errors include
+// the indexes of the elements in the path.
+func decodeArray(arr *core.NDArray, dst reflect.Value, path []string) error {
+	fail := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf("cannot decode %s into %s: %s", arr.String(), dst.Type(),
+			fmt.Sprintf(format, args...))
+		if len(path) == 0 {
+			return errors.New(msg)
+		}
+		return errors.Errorf("%s: %s", strings.Join(path, "."), msg)
+	}
+	if len(arr.Shape) == 0 {
+		return fail("zero-dimensional arrays are not supported")
+	}
+	elemType := dst.Type()
+	for _, dim := range arr.Shape {
+		if elemType.Kind() != reflect.Slice {
+			return fail("%d dimensions are required", len(arr.Shape))
+		}
+		if dim < 0 {
+			return fail("the array is not loaded")
+		}
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() == reflect.Slice {
+		return fail("%d dimensions are required", len(arr.Shape))
+	}
+	if !isNumericKind(elemType.Kind()) && elemType.Kind() != reflect.Bool &&
+		elemType.Kind() != reflect.String {
+		return fail("unsupported element type %s", elemType)
+	}
+	if flat, err := bulkElements(arr, elemType); err != nil {
+		return fail("%v", err)
+	} else if flat.IsValid() {
+		splitRows(flat, dst, arr.Shape, 0)
+		return nil
+	}
+	// the numbers convert to each other except for the complex numbers
+	elementClass := func(kind reflect.Kind) reflect.Kind {
+		switch kind {
+		case reflect.Complex64, reflect.Complex128:
+			return reflect.Complex128
+		case reflect.Bool, reflect.String:
+			return kind
+		}
+		return reflect.Float64
+	}
+	var fill func(dst reflect.Value, index []int) error
+	fill = func(dst reflect.Value, index []int) error {
+		size := arr.Shape[len(index)]
+		result := reflect.MakeSlice(dst.Type(), size, size)
+		index = append(index, 0)
+		for i := 0; i < size; i++ {
+			index[len(index)-1] = i
+			if len(index) < len(arr.Shape) {
+				if err := fill(result.Index(i), index); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := arr.At(index...)
+			if err != nil {
+				return fail("%v", err)
+			}
+			elem := reflect.ValueOf(value)
+			if !elem.IsValid() {
+				return fail("unsupported data type %s", arr.DataType)
+			}
+			if elementClass(elem.Kind()) != elementClass(elemType.Kind()) {
+				return fail("cannot convert %s to %s", elem.Type(), elemType)
+			}
+			converted, err := convertNumber(elem, elemType)
+			if err != nil {
+				elemPath := path
+				for _, pos := range index {
+					elemPath = appendPath(elemPath, strconv.Itoa(pos))
+				}
+				return errors.Errorf("%s: %v", strings.Join(elemPath, "."), err)
+			}
+			result.Index(i).Set(converted)
+		}
+		dst.Set(result)
+		return nil
+	}
+	return fill(dst, make([]int, 0, len(arr.Shape)))
+}
+
+// bulkElements returns the elements of the array in C order as a new slice of `elemType` if
+// they are read by a typed accessor and need no overflow checks. Otherwise, the returned value
+// is invalid and the elements must be converted one by one.
+func bulkElements(arr *core.NDArray, elemType reflect.Type) (reflect.Value, error) {
+	var elements interface{}
+	var err error
+	switch {
+	case arr.DataType == core.Float16:
+		elements, err = arr.Float16s()
+	case arr.DataType == core.ASCII || arr.DataType == core.UCS4:
+		elements, err = arr.Strings()
+	default:
+		accessor, exists := bulkAccessors[arr.DataType.Kind()]
+		if !exists {
+			return reflect.Value{}, nil
+		}
+		elements, err = accessor(arr)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	src := reflect.ValueOf(elements)
+	flat := reflect.MakeSlice(reflect.SliceOf(elemType), src.Len(), src.Len())
+	if src.Type().Elem() == elemType {
+		// the accessors may share the memory with the array
+		reflect.Copy(flat, src)
+		return flat, nil
+	}
+	if elemType.Kind() != reflect.Float64 {
+		return reflect.Value{}, nil
+	}
+	// float32 widens to float64 exactly
+	floats, ok := elements.([]float32)
+	if !ok {
+		return reflect.Value{}, nil
+	}
+	for i, value := range floats {
+		flat.Index(i).SetFloat(float64(value))
+	}
+	return flat, nil
+}
+
+// bulkAccessors map the basic data types to the corresponding typed accessors of NDArray.
+var bulkAccessors = map[types.BasicKind]func(arr *core.NDArray) (interface{}, error){
+	types.Bool:       func(arr *core.NDArray) (interface{}, error) { return arr.Bools() },
+	types.Int8:       func(arr *core.NDArray) (interface{}, error) { return arr.Int8s() },
+	types.Int16:      func(arr *core.NDArray) (interface{}, error) { return arr.Int16s() },
+	types.Int32:      func(arr *core.NDArray) (interface{}, error) { return arr.Int32s() },
+	types.Int64:      func(arr *core.NDArray) (interface{}, error) { return arr.Int64s() },
+	types.Uint8:      func(arr *core.NDArray) (interface{}, error) { return arr.Uint8s() },
+	types.Uint16:     func(arr *core.NDArray) (interface{}, error) { return arr.Uint16s() },
+	types.Uint32:     func(arr *core.NDArray) (interface{}, error) { return arr.Uint32s() },
+	types.Uint64:     func(arr *core.NDArray) (interface{}, error) { return arr.Uint64s() },
+	types.Float32:    func(arr *core.NDArray) (interface{}, error) { return arr.Float32s() },
+	types.Float64:    func(arr *core.NDArray) (interface{}, error) { return arr.Float64s() },
+	types.Complex64:  func(arr *core.NDArray) (interface{}, error) { return arr.Complex64s() },
+	types.Complex128: func(arr *core.NDArray) (interface{}, error) { return arr.Complex128s() },
+}
+
+// splitRows assigns the nested slices which share the memory with `flat` to `dst`.
+func splitRows(flat reflect.Value, dst reflect.Value, shape []int, offset int) {
+	if len(shape) == 1 {
+		dst.Set(flat.Slice3(offset, offset+shape[0], offset+shape[0]).Convert(dst.Type()))
+		return
+	}
+	stride := 1
+	for _, dim := range shape[1:] {
+		stride *= dim
+	}
+	result := reflect.MakeSlice(dst.Type(), shape[0], shape[0])
+	for i := 0; i < shape[0]; i++ {
+		splitRows(flat, result.Index(i), shape[1:], offset+i*stride)
+	}
+	dst.Set(result)
+}
+
+// convertNumber converts the array element to `dstType` of the same class with the overflow and
+// truncation checks.
+func convertNumber(src reflect.Value, dstType reflect.Type) (reflect.Value, error) {
+	dst := reflect.New(dstType).Elem()
+	notInteger := func() error {
+		return errors.Errorf("%v is not an integer", src.Interface())
+	}
+	switch dstType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var number int64
+		switch src.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if src.Uint() > math.MaxInt64 {
+				return dst, errors.Errorf("%d overflows %s", src.Uint(), dstType)
+			}
+			number = int64(src.Uint())
+		case reflect.Float32, reflect.Float64:
+			val := src.Float()
+			if val != math.Trunc(val) {
+				return dst, notInteger()
+			}
+			if val < math.MinInt64 || val >= math.MaxInt64 {
+				return dst, errors.Errorf("%v overflows %s", val, dstType)
+			}
+			number = int64(val)
+		default:
+			number = src.Int()
+		}
+		if dst.OverflowInt(number) {
+			return dst, errors.Errorf("%d overflows %s", number, dstType)
+		}
+		dst.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var number uint64
+		switch src.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 {
+				return dst, errors.Errorf("%d overflows %s", src.Int(), dstType)
+			}
+			number = uint64(src.Int())
+		case reflect.Float32, reflect.Float64:
+			val := src.Float()
+			if val != math.Trunc(val) {
+				return dst, notInteger()
+			}
+			if val < 0 || val >= math.MaxUint64 {
+				return dst, errors.Errorf("%v overflows %s", val, dstType)
+			}
+			number = uint64(val)
+		default:
+			number = src.Uint()
+		}
+		if dst.OverflowUint(number) {
+			return dst, errors.Errorf("%d overflows %s", number, dstType)
+		}
+		dst.SetUint(number)
+	case reflect.Float32, reflect.Float64:
+		var number float64
+		switch src.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			number = float64(src.Int())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			number = float64(src.Uint())
+		default:
+			number = src.Float()
+		}
+		if dst.OverflowFloat(number) {
+			return dst, errors.Errorf("%v overflows %s", number, dstType)
+		}
+		dst.SetFloat(number)
+	case reflect.Complex64, reflect.Complex128:
+		if dst.OverflowComplex(src.Complex()) {
+			return dst, errors.Errorf("%v overflows %s", src.Complex(), dstType)
+		}
+		dst.SetComplex(src.Complex())
+	default:
+		// bool and string
+		dst.Set(src.Convert(dstType))
+	}
+	return dst, nil
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Complex128 && kind != reflect.Uintptr
+}
+
+func describeSource(src interface{}) string {
+	switch val := src.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case int, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprintf("%T", src)
+}
+
+func appendPath(path []string, key string) []string {
+	return append(path[:len(path):len(path)], key)
+}
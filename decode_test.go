@@ -0,0 +1,246 @@
+package asdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go/types"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+type defaultTree struct {
+	Library *core.Software `asdf:"asdf_library"`
+	History core.History   `asdf:"history"`
+	Arrs    []*core.NDArray
+	One     struct {
+		Four struct {
+			Five []int32 `asdf:"five"`
+		} `asdf:"four"`
+		Three   []float64 `asdf:"three"`
+		Two     []uint8
+		Ignored string `asdf:"-"`
+	} `asdf:"one"`
+}
+
+func TestFileDecode(t *testing.T) {
+	req := require.New(t)
+	for _, lazy := range []bool{false, true} {
+		file, err := OpenFileWithOptions("testdata/default.asdf", OpenOptions{Lazy: lazy})
+		req.NoError(err)
+		var tree defaultTree
+		tree.One.Ignored = "untouched"
+		req.NoError(file.Decode(&tree))
+		req.Equal("asdf", tree.Library.Name)
+		req.Equal("2.4.2", tree.Library.Version.String())
+		req.Len(tree.History.Extensions, 1)
+		req.Len(tree.Arrs, 3)
+		req.Equal([]int{500, 3}, tree.Arrs[1].Shape)
+		req.Equal([]float64{0.1, 0.2, 0.3}, tree.One.Three)
+		req.Equal([]uint8{0, 1, 2}, tree.One.Two)
+		req.Equal("untouched", tree.One.Ignored)
+		req.Len(tree.One.Four.Five, 1000)
+		five := file.Tree.Path("one.four.five").Data().(*core.NDArray)
+		for i, val := range tree.One.Four.Five {
+			req.Equal(int32(binary.LittleEndian.Uint32(five.Data[i*4:])), val)
+		}
+
+		var arrays struct {
+			Arrs []interface{} `asdf:"arrs"`
+		}
+		req.NoError(file.Decode(&arrays))
+		var pixels [][]float32
+		req.NoError(decodeValue(arrays.Arrs[1], reflectValue(&pixels), nil))
+		req.Len(pixels, 500)
+		req.Len(pixels[7], 3)
+		req.Equal(float32(tree.Arrs[1].Data[7*3+2]), pixels[7][2])
+		var doubles []float64
+		req.NoError(decodeValue(arrays.Arrs[2], reflectValue(&doubles), nil))
+		req.Len(doubles, 10)
+		req.Equal(math.Float64frombits(binary.LittleEndian.Uint64(tree.Arrs[2].Data[8:])),
+			doubles[1])
+		req.NoError(file.Close())
+	}
+}
+
+func TestFileDecodeErrors(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFile("testdata/default.asdf", nil)
+	req.NoError(err)
+	req.Error(file.Decode(defaultTree{}))
+	req.Error(file.Decode((*defaultTree)(nil)))
+	var strs struct {
+		One struct {
+			Two []string
+		}
+	}
+	err = file.Decode(&strs)
+	req.EqualError(err, "one.two.0: cannot decode number into string")
+	var dims struct {
+		Arrs [][]float64
+	}
+	err = file.Decode(&dims)
+	req.Error(err)
+	req.Contains(err.Error(), "arrs.1: cannot decode array<uint8, BigEndian> of shape [500, 3] "+
+		"into []float64: 2 dimensions are required")
+	var narrow struct {
+		One struct {
+			Three []int
+		}
+	}
+	req.EqualError(file.Decode(&narrow), "one.three.0: cannot decode number into int")
+	var library struct {
+		Library string `asdf:"asdf_library"`
+	}
+	req.Error(file.Decode(&library))
+	var object []int
+	req.EqualError(file.Decode(&object), "cannot decode object into []int")
+}
+
+func TestFileDecodeArrayOverflow(t *testing.T) {
+	req := require.New(t)
+	file, err := Open(bytes.NewReader([]byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+arr: !core/ndarray-1.0.0 {data: [[300, -1, 2.5], [1e40, 1, 2]], datatype: float64}
+big: !core/ndarray-1.0.0 {data: [18446744073709551615], datatype: uint64}
+...
+`)), nil)
+	req.NoError(err)
+	var int8s struct {
+		Arr [][]int8
+	}
+	req.EqualError(file.Decode(&int8s), "arr.0.0: 300 overflows int8")
+	var uint16s struct {
+		Arr [][]uint16
+	}
+	req.EqualError(file.Decode(&uint16s), "arr.0.1: -1 overflows uint16")
+	var ints struct {
+		Arr [][]int
+	}
+	req.EqualError(file.Decode(&ints), "arr.0.2: 2.5 is not an integer")
+	var float32s struct {
+		Arr [][]float32
+	}
+	req.EqualError(file.Decode(&float32s), "arr.1.0: 1e+40 overflows float32")
+	var int64s struct {
+		Big []int64
+	}
+	req.EqualError(file.Decode(&int64s), "big.0: 18446744073709551615 overflows int64")
+	var valid struct {
+		Big []uint64
+	}
+	req.NoError(file.Decode(&valid))
+	req.Equal([]uint64{math.MaxUint64}, valid.Big)
+}
+
+func TestFileDecodeArrayBulk(t *testing.T) {
+	req := require.New(t)
+	arr := &core.NDArray{
+		DataType:  types.Typ[types.Float32],
+		ByteOrder: binary.LittleEndian,
+		Shape:     []int{1000, 4},
+		Data:      make([]byte, 1000*4*4),
+	}
+	for i := 0; i < 4000; i++ {
+		binary.LittleEndian.PutUint32(arr.Data[i*4:], math.Float32bits(float32(i)/2))
+	}
+	file := &File{Document: core.Document{Tree: gabs.New()}}
+	_, err := file.Tree.Set(arr, "arr")
+	req.NoError(err)
+	var float32s struct {
+		Arr [][]float32
+	}
+	var float64s struct {
+		Arr [][]float64
+	}
+	type celsius float64
+	var named struct {
+		Arr [][]celsius
+	}
+	for _, v := range []interface{}{&float32s, &float64s, &named} {
+		// one allocation per row instead of one per element
+		allocs := testing.AllocsPerRun(3, func() {
+			req.NoError(file.Decode(v))
+		})
+		if v != &named {
+			req.Less(allocs, float64(1100))
+		}
+	}
+	req.Len(float32s.Arr, 1000)
+	req.Equal([]float32{1998, 1998.5, 1999, 1999.5}, float32s.Arr[999])
+	req.Equal([]float64{2, 2.5, 3, 3.5}, float64s.Arr[1])
+	req.Equal([]celsius{0, 0.5, 1, 1.5}, named.Arr[0])
+	// the decoded slices do not share the memory with the array
+	float32s.Arr[0][0] = 100
+	req.Equal([]byte{0, 0, 0, 0}, arr.Data[:4])
+}
+
+func TestFileDecodePath(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFile("testdata/default.asdf", nil)
+	req.NoError(err)
+	var three []float64
+	req.NoError(file.DecodePath("one.three", &three))
+	req.Equal([]float64{0.1, 0.2, 0.3}, three)
+	var pixels [][]float32
+	req.NoError(file.DecodePath("arrs.1", &pixels))
+	req.Len(pixels, 500)
+	var library core.Software
+	req.NoError(file.DecodePath("asdf_library", &library))
+	req.Equal("asdf", library.Name)
+	var strs []string
+	req.EqualError(file.DecodePath("one.two", &strs), "one.two.0: cannot decode number into string")
+	req.EqualError(file.DecodePath("one.missing", &strs), "one.missing does not exist")
+	req.Error(file.DecodePath("one.three", three))
+}
+
+func TestFileDecodeFieldNames(t *testing.T) {
+	req := require.New(t)
+	file, err := Open(bytes.NewReader([]byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+FOO: 1
+Foo: 2
+foo: 3
+bar: 4
+BAR: 5
+BAZ: 6
+...
+`)), nil)
+	req.NoError(err)
+	for i := 0; i < 10; i++ {
+		var tree struct {
+			Foo int
+			Bar int
+			Baz int `asdf:"baz"`
+		}
+		req.NoError(file.Decode(&tree))
+		req.Equal(2, tree.Foo)
+		req.Equal(5, tree.Bar)
+		req.Equal(0, tree.Baz)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	req := require.New(t)
+	var tree struct {
+		Data []int64
+	}
+	req.NoError(Decode("testdata/standard/exploded.asdf", &tree))
+	req.Equal([]int64{0, 1, 2, 3, 4, 5, 6, 7}, tree.Data)
+	req.Error(Decode("testdata/standard/missing.asdf", &tree))
+}
+
+func reflectValue(ptr interface{}) reflect.Value {
+	return reflect.ValueOf(ptr).Elem()
+}
@@ -0,0 +1,340 @@
+package asdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/types"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/src-d/go-asdf/schema"
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+// MarshalOptions tune converting Go values to ASDF.
+type MarshalOptions struct {
+	// InlineThreshold is the maximum number of elements in the arrays which are written inline
+	// in the tree instead of separate binary blocks. Zero means that all the arrays are written
+	// to blocks. The complex arrays are never inline.
+	InlineThreshold int
+}
+
+// Marshal converts `v` to ASDF, similar to `json.Marshal`. See MarshalWithOptions.
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalWithOptions(v, MarshalOptions{})
+}
+
+// MarshalWithOptions converts `v`, which must be a struct or a map with string keys, to ASDF.
+// The struct fields are named after the `asdf:"name,omitempty"` tags, or after the fields
+// themselves if there are no tags; "-" skips the field, and "omitempty" skips the zero values.
+// The top level `core.Software` and `core.History` named "asdf_library" and "history" become
+// the document's `Library` and `History`. `*core.NDArray` and the numeric or boolean slices, e.g.
+// `[]float64` or `[][]bool`, are written as core/ndarray; the nested slices must be rectangular.
+// The values which implement `schema.Marshaler` convert themselves.
+func MarshalWithOptions(v interface{}, options MarshalOptions) ([]byte, error) {
+	file, err := marshalFile(v, options)
+	if err != nil {
+		return nil, err
+	}
+	buffer := &bytes.Buffer{}
+	if _, err = file.WriteTo(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// marshalFile converts `v` to the File which is ready to be written.
+func marshalFile(v interface{}, options MarshalOptions) (*File, error) {
+	val, err := marshalValue(reflect.ValueOf(v), nil, options)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("cannot marshal %T: must be a struct or a map", v)
+	}
+	file := &File{}
+	// nil values are treated as absent
+	if lib, exists := tree["asdf_library"]; exists {
+		switch typed := lib.(type) {
+		case nil:
+		case core.Software:
+			file.Library = &typed
+		case *core.Software:
+			file.Library = typed
+		default:
+			return nil, errors.Errorf("asdf_library: cannot marshal %T, must be core.Software", lib)
+		}
+		delete(tree, "asdf_library")
+	}
+	if history, exists := tree["history"]; exists {
+		switch typed := history.(type) {
+		case nil:
+		case core.History:
+			file.History = &typed
+		case *core.History:
+			file.History = typed
+		default:
+			return nil, errors.Errorf("history: cannot marshal %T, must be core.History", history)
+		}
+		delete(tree, "history")
+	}
+	file.Tree = gabs.Wrap(tree)
+	return file, nil
+}
+
+var (
+	marshalerType = reflect.TypeOf((*schema.Marshaler)(nil)).Elem()
+	ndarrayType   = reflect.TypeOf(core.NDArray{})
+)
+
+// marshalValue converts the Go value to the object which `schema.YAMLifyGabs` understands.
+func marshalValue(val reflect.Value, path []string, options MarshalOptions) (
+	interface{}, error) {
+	fail := func(format string, args ...interface{}) error {
+		if len(path) == 0 {
+			return errors.Errorf(format, args...)
+		}
+		return errors.Errorf("%s: %s", strings.Join(path, "."), fmt.Sprintf(format, args...))
+	}
+	for val.Kind() == reflect.Interface ||
+		(val.Kind() == reflect.Ptr && !val.Type().Implements(marshalerType)) {
+		if val.IsNil() {
+			return nil, nil
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() {
+		return nil, nil
+	}
+	if val.Type() == ndarrayType {
+		arr := val.Interface().(core.NDArray)
+		return marshalArray(&arr, options), nil
+	}
+	if arr, ok := val.Interface().(*core.NDArray); ok {
+		if arr == nil {
+			return nil, nil
+		}
+		return marshalArray(arr, options), nil
+	}
+	if val.Type().Implements(marshalerType) {
+		if val.Kind() == reflect.Ptr && val.IsNil() {
+			return nil, nil
+		}
+		return val.Interface(), nil
+	}
+	if _, isNode := val.Interface().(yaml.Node); isNode {
+		node := val.Interface().(yaml.Node)
+		return &node, nil
+	}
+	switch val.Kind() {
+	case reflect.Struct:
+		object := map[string]interface{}{}
+		for _, field := range structFields(val.Type()) {
+			fieldVal := val.FieldByIndex(field.index)
+			if field.omitEmpty && isEmptyValue(fieldVal) {
+				continue
+			}
+			sub, err := marshalValue(fieldVal, appendPath(path, field.name), options)
+			if err != nil {
+				return nil, err
+			}
+			object[field.name] = sub
+		}
+		return object, nil
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			return nil, fail("cannot marshal %s: the keys must be strings", val.Type())
+		}
+		if val.IsNil() {
+			return nil, nil
+		}
+		object := map[string]interface{}{}
+		for _, key := range val.MapKeys() {
+			name := key.String()
+			sub, err := marshalValue(val.MapIndex(key), appendPath(path, name), options)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = sub
+		}
+		return object, nil
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.IsNil() {
+			return nil, nil
+		}
+		if arr, ok, err := sliceToArray(val); ok {
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			return marshalArray(arr, options), nil
+		}
+		list := make([]interface{}, val.Len())
+		for i := range list {
+			sub, err := marshalValue(val.Index(i), appendPath(path, strconv.Itoa(i)), options)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = sub
+		}
+		return list, nil
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return val.Interface(), nil
+	}
+	return nil, fail("cannot marshal %s", val.Type())
+}
+
+// marshalArray decides whether the array should be inline.
+func marshalArray(arr *core.NDArray, options MarshalOptions) *core.NDArray {
+	inline := options.InlineThreshold > 0 && arr.DataType != nil &&
+		(arr.DataType.Info()&types.IsComplex) == 0 && len(arr.Shape) > 0 && arr.Shape[0] >= 0 &&
+		arr.CountElements() <= options.InlineThreshold
+	if inline == arr.Inline {
+		return arr
+	}
+	copied := *arr
+	copied.Inline = inline
+	return &copied
+}
+
+// sliceElementTypes maps the supported element kinds of the slices which become arrays.
+var sliceElementTypes = map[reflect.Kind]*types.Basic{
+	reflect.Bool:       types.Typ[types.Bool],
+	reflect.Int8:       types.Typ[types.Int8],
+	reflect.Int16:      types.Typ[types.Int16],
+	reflect.Int32:      types.Typ[types.Int32],
+	reflect.Int64:      types.Typ[types.Int64],
+	reflect.Int:        types.Typ[types.Int64],
+	reflect.Uint8:      types.Typ[types.Uint8],
+	reflect.Uint16:     types.Typ[types.Uint16],
+	reflect.Uint32:     types.Typ[types.Uint32],
+	reflect.Uint64:     types.Typ[types.Uint64],
+	reflect.Uint:       types.Typ[types.Uint64],
+	reflect.Float32:    types.Typ[types.Float32],
+	reflect.Float64:    types.Typ[types.Float64],
+	reflect.Complex64:  types.Typ[types.Complex64],
+	reflect.Complex128: types.Typ[types.Complex128],
+}
+
+// sliceToArray converts the nested numeric or boolean slice to an NDArray in little endian
+// byte order.
+// The second returned value indicates whether the slice has the supported element type.
+func sliceToArray(val reflect.Value) (*core.NDArray, bool, error) {
+	elemType := val.Type()
+	ndim := 0
+	for elemType.Kind() == reflect.Slice || elemType.Kind() == reflect.Array {
+		elemType = elemType.Elem()
+		ndim++
+	}
+	dtype, exists := sliceElementTypes[elemType.Kind()]
+	if !exists {
+		return nil, false, nil
+	}
+	arr := &core.NDArray{DataType: dtype, ByteOrder: binary.LittleEndian, Shape: make([]int, ndim)}
+	for i, sub := 0, val; i < ndim; i++ {
+		arr.Shape[i] = sub.Len()
+		if sub.Len() == 0 {
+			break
+		}
+		sub = sub.Index(0)
+	}
+	arr.Data = make([]byte, 0, arr.CountBytes())
+	var flatten func(sub reflect.Value, dim int) error
+	flatten = func(sub reflect.Value, dim int) error {
+		if dim == ndim {
+			arr.Data = appendElement(arr.Data, sub)
+			return nil
+		}
+		if sub.Len() != arr.Shape[dim] {
+			return errors.Errorf("the slice is not rectangular: dimension %d has lengths %d and %d",
+				dim, arr.Shape[dim], sub.Len())
+		}
+		for i := 0; i < sub.Len(); i++ {
+			if err := flatten(sub.Index(i), dim+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := flatten(val, 0); err != nil {
+		return nil, true, err
+	}
+	return arr, true, nil
+}
+
+// appendElement writes the number in little endian byte order.
+func appendElement(data []byte, val reflect.Value) []byte {
+	var buffer [16]byte
+	order := binary.LittleEndian
+	switch val.Kind() {
+	case reflect.Bool:
+		if val.Bool() {
+			return append(data, 1)
+		}
+		return append(data, 0)
+	case reflect.Int8, reflect.Uint8:
+		return append(data, byte(val.Convert(reflect.TypeOf(uint8(0))).Uint()))
+	case reflect.Int16:
+		order.PutUint16(buffer[:], uint16(val.Int()))
+		return append(data, buffer[:2]...)
+	case reflect.Uint16:
+		order.PutUint16(buffer[:], uint16(val.Uint()))
+		return append(data, buffer[:2]...)
+	case reflect.Int32:
+		order.PutUint32(buffer[:], uint32(val.Int()))
+		return append(data, buffer[:4]...)
+	case reflect.Uint32:
+		order.PutUint32(buffer[:], uint32(val.Uint()))
+		return append(data, buffer[:4]...)
+	case reflect.Int64, reflect.Int:
+		order.PutUint64(buffer[:], uint64(val.Int()))
+		return append(data, buffer[:8]...)
+	case reflect.Uint64, reflect.Uint:
+		order.PutUint64(buffer[:], val.Uint())
+		return append(data, buffer[:8]...)
+	case reflect.Float32:
+		order.PutUint32(buffer[:], math.Float32bits(float32(val.Float())))
+		return append(data, buffer[:4]...)
+	case reflect.Float64:
+		order.PutUint64(buffer[:], math.Float64bits(val.Float()))
+		return append(data, buffer[:8]...)
+	case reflect.Complex64:
+		order.PutUint32(buffer[:], math.Float32bits(float32(real(val.Complex()))))
+		order.PutUint32(buffer[4:], math.Float32bits(float32(imag(val.Complex()))))
+		return append(data, buffer[:8]...)
+	case reflect.Complex128:
+		order.PutUint64(buffer[:], math.Float64bits(real(val.Complex())))
+		order.PutUint64(buffer[8:], math.Float64bits(imag(val.Complex())))
+		return append(data, buffer[:16]...)
+	}
+	return data
+}
+
+// isEmptyValue follows the "omitempty" semantics of encoding/json.
+func isEmptyValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return val.Len() == 0
+	case reflect.Bool:
+		return !val.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		return val.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return val.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return val.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package asdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/go-asdf/schema"
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+type encodedTree struct {
+	Library *core.Software `asdf:"asdf_library"`
+	History core.History   `asdf:"history"`
+	Name    string         `asdf:"name"`
+	Comment string         `asdf:"comment,omitempty"`
+	Skipped int            `asdf:"-"`
+	Signal  []float64      `asdf:"signal"`
+	Image   [][]float32    `asdf:"image"`
+	Labels  []string       `asdf:"labels"`
+	Extra   map[string]int `asdf:"extra,omitempty"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	req := require.New(t)
+	tree := encodedTree{
+		Library: &core.Software{
+			Tag: schema.Tag{Name: "go-asdf-test", Version: semver.MustParse("1.2.3")},
+		},
+		History: core.History{Entries: []*core.HistoryEntry{{Description: "created"}}},
+		Name:    "test",
+		Skipped: 7,
+		Signal:  []float64{1, 2.5, -3},
+		Image:   [][]float32{{1, 2, 3}, {4, 5, 6}},
+		Labels:  []string{"a", "b"},
+	}
+	data, err := Marshal(tree)
+	req.NoError(err)
+	req.NotContains(string(data), "comment")
+	req.NotContains(string(data), "extra")
+	req.NotContains(string(data), "Skipped")
+	file, err := Open(bytes.NewReader(data), nil)
+	req.NoError(err)
+	req.Equal("go-asdf-test", file.Library.Name)
+	req.Len(file.History.Entries, 1)
+	req.Equal("created", file.History.Entries[0].Description)
+	signal := file.Tree.Path("signal").Data().(*core.NDArray)
+	req.Equal([]int{3}, signal.Shape)
+	req.False(signal.Inline)
+	req.Equal([]int{2, 3}, file.Tree.Path("image").Data().(*core.NDArray).Shape)
+
+	var decoded encodedTree
+	req.NoError(file.Decode(&decoded))
+	req.Equal(tree.Signal, decoded.Signal)
+	req.Equal(tree.Image, decoded.Image)
+	req.Equal(tree.Labels, decoded.Labels)
+	req.Equal("test", decoded.Name)
+	req.Equal(0, decoded.Skipped)
+	req.Len(decoded.History.Entries, 1)
+}
+
+func TestMarshalNilLibraryAndHistory(t *testing.T) {
+	req := require.New(t)
+	tree := struct {
+		Library *core.Software `asdf:"asdf_library"`
+		History *core.History  `asdf:"history"`
+		Name    string         `asdf:"name"`
+	}{Name: "test"}
+	file, err := marshalFile(tree, MarshalOptions{})
+	req.NoError(err)
+	req.Nil(file.Library)
+	req.Nil(file.History)
+	req.False(file.Tree.Exists("asdf_library"))
+	req.False(file.Tree.Exists("history"))
+	data, err := Marshal(tree)
+	req.NoError(err)
+	file, err = Open(bytes.NewReader(data), nil)
+	req.NoError(err)
+	req.Equal("test", file.Tree.Path("name").Data())
+}
+
+func TestMarshalInline(t *testing.T) {
+	req := require.New(t)
+	tree := map[string]interface{}{
+		"small":   []uint16{1, 2, 3},
+		"matrix":  [][]float64{{0.5, 1}, {2, 3}},
+		"large":   make([]float32, 10),
+		"complex": []complex64{1 + 2i},
+		"flags":   [][]bool{{true}, {false}},
+	}
+	data, err := MarshalWithOptions(tree, MarshalOptions{InlineThreshold: 4})
+	req.NoError(err)
+	req.Contains(string(data), "data: [1, 2, 3]")
+	req.Contains(string(data), "data: [[0.5, 1.0], [2.0, 3.0]]")
+	req.Contains(string(data), "data: [[true], [false]]")
+	file, err := Open(bytes.NewReader(data), nil)
+	req.NoError(err)
+	req.True(file.Tree.Path("small").Data().(*core.NDArray).Inline)
+	req.True(file.Tree.Path("matrix").Data().(*core.NDArray).Inline)
+	req.False(file.Tree.Path("large").Data().(*core.NDArray).Inline)
+	req.False(file.Tree.Path("complex").Data().(*core.NDArray).Inline)
+	var decoded struct {
+		Small  []uint16    `asdf:"small"`
+		Matrix [][]float64 `asdf:"matrix"`
+		Large  []float32   `asdf:"large"`
+		Flags  [][]bool    `asdf:"flags"`
+	}
+	req.NoError(file.Decode(&decoded))
+	req.Equal(tree["small"], decoded.Small)
+	req.Equal(tree["matrix"], decoded.Matrix)
+	req.Equal(tree["large"], decoded.Large)
+	req.Equal(tree["flags"], decoded.Flags)
+}
+
+func TestMarshalErrors(t *testing.T) {
+	req := require.New(t)
+	_, err := Marshal([]int{1, 2})
+	req.Error(err)
+	_, err = Marshal(map[string]interface{}{"outer": map[string]interface{}{
+		"ragged": [][]int{{1, 2}, {3}}}})
+	req.Error(err)
+	req.True(strings.HasPrefix(err.Error(), "outer.ragged: the slice is not rectangular"),
+		err.Error())
+	_, err = Marshal(map[string]interface{}{"ch": make(chan int)})
+	req.EqualError(err, "ch: cannot marshal chan int")
+	_, err = Marshal(map[string]interface{}{"asdf_library": "go-asdf"})
+	req.Error(err)
+}
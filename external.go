@@ -0,0 +1,106 @@
+package asdf
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Resolver opens the external ASDF file referenced by the `source` of an array in the "exploded"
+// form. If the returned reader implements io.Closer, it is closed after the block is read.
+type Resolver func(uri string) (io.ReadSeeker, error)
+
+// DirectoryResolver returns the Resolver which opens the files relative to the specified directory.
+// Only "file" URIs are supported. The absolute paths and the paths which lead outside of `dir`
+// are rejected, so that an untrusted file cannot reference arbitrary local files.
+func DirectoryResolver(dir string) Resolver {
+	return func(uri string) (io.ReadSeeker, error) {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid external block URI: %s", uri)
+		}
+		if parsed.Scheme != "" && parsed.Scheme != "file" {
+			return nil, errors.Errorf("unsupported external block URI scheme: %s", uri)
+		}
+		path := filepath.FromSlash(parsed.Path)
+		if filepath.IsAbs(path) || filepath.VolumeName(path) != "" ||
+			strings.HasPrefix(path, string(filepath.Separator)) {
+			return nil, errors.Errorf("absolute external block paths are not allowed: %s", uri)
+		}
+		path = filepath.Clean(path)
+		if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, errors.Errorf("the external block is outside of %s: %s", dir, uri)
+		}
+		file, err := os.Open(filepath.Join(dir, path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open the external block %s", uri)
+		}
+		return file, nil
+	}
+}
+
+// readExternalBlock loads and uncompresses the first block of the referenced ASDF file.
+func readExternalBlock(ctx context.Context, resolver Resolver, uri string,
+	options blockOptions) ([]byte, error) {
+	var block *Block
+	err := openExternalBlocks(resolver, uri, options, func(blocks *blockReader) error {
+		var err error
+		block, err = blocks.ReadBlock(0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = block.uncompress(ctx, options); err != nil {
+		return nil, errors.Wrapf(err, "while uncompressing the external block %s", uri)
+	}
+	return block.Data, nil
+}
+
+// readExternalSize returns the size of the first block of the referenced ASDF file according
+// to its header. The payload is not read.
+func readExternalSize(resolver Resolver, uri string, options blockOptions) (blockSize, error) {
+	var size blockSize
+	err := openExternalBlocks(resolver, uri, options, func(blocks *blockReader) error {
+		var err error
+		size, err = blocks.ReadSize(0)
+		return err
+	})
+	return size, err
+}
+
+// openExternalBlocks opens the referenced ASDF file and passes the reader of its blocks
+// to `visit`. The file is closed afterwards.
+func openExternalBlocks(resolver Resolver, uri string, options blockOptions,
+	visit func(blocks *blockReader) error) error {
+	if resolver == nil {
+		return errors.Errorf("cannot open the external block %s: no resolver", uri)
+	}
+	reader, err := resolver(uri)
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if _, _, err = parseHeader(reader); err != nil {
+		return errors.Wrapf(err, "while reading the external block %s", uri)
+	}
+	border, borderLen, err := findBorder(reader)
+	if err != nil {
+		return errors.Wrapf(err, "while reading the external block %s", uri)
+	}
+	if border < 0 {
+		return errors.Errorf("%s does not contain binary blocks", uri)
+	}
+	err = visit(newBlockReader(reader, int64(border+borderLen-len(blockMagic)), options))
+	if err != nil {
+		return errors.Wrapf(err, "while reading the external block %s", uri)
+	}
+	return nil
+}
@@ -3,13 +3,15 @@ package asdf
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
 	"io"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/blang/semver"
 	"github.com/pkg/errors"
-	"golang.org/x/exp/mmap"
 	"gopkg.in/yaml.v3"
 
 	"github.com/src-d/go-asdf/schema"
@@ -23,11 +25,62 @@ type File struct {
 	FormatVersion semver.Version
 	// FormatVersion corresponds to the contents of #ASDF_STANDARD header comment.
 	StandardVersion semver.Version
+	// Diagnostics lists the problems with the tree which were tolerated in lenient mode.
+	Diagnostics []schema.Diagnostic
+
+	// closer releases the resources held by the lazily loaded or the mapped file.
+	closer io.Closer
 }
 
-// ProgressCallback allows tracking the file loading progress. Both done *and* total will grow dynamically.
+// ProgressCallback allows tracking the file loading progress. `total` is 2 until the tree is
+// parsed, then it becomes the number of the referenced blocks plus 2. `ProgressListener` reports
+// the progress in more detail.
 type ProgressCallback func(done, total int)
 
+// OpenOptions tune reading ASDF files.
+type OpenOptions struct {
+	// Progress is called as the file is being loaded. It may be nil.
+	Progress ProgressCallback
+	// Listener receives the structured progress events with the byte counters. It may be nil.
+	Listener ProgressListener
+	// Lazy postpones reading the binary blocks until `NDArray.Load()` is called.
+	// The unused blocks are never read and uncompressed.
+	Lazy bool
+	// Mmap makes `Data` of the uncompressed arrays reference the memory mapped file instead of
+	// being copied, so that the big files do not have to fit into RAM. Such `Data` must not be
+	// used after `File.Close()`. It has effect only in `OpenFile` on the platforms which support
+	// it, the blocks are copied otherwise.
+	Mmap bool
+	// Resolver opens the external files referenced by the arrays. `OpenFile` defaults to
+	// resolving relative to the opened file's directory.
+	Resolver Resolver
+	// Concurrency is the number of goroutines which uncompress the blocks, verify the checksums
+	// and copy the strided arrays. The blocks are still read sequentially, and the progress is
+	// reported in the same order regardless. Zero means one goroutine. It has no effect if the
+	// file is opened lazily.
+	Concurrency int
+	// SkipChecksums disables verifying the MD5 checksums of the blocks.
+	SkipChecksums bool
+	// MaxBlockSize limits the size of each block, both compressed and uncompressed, to protect
+	// against malicious files. Zero means no limit.
+	MaxBlockSize uint64
+	// Strict turns every unsupported tag, key or data type into an error. Otherwise, they are
+	// kept as raw YAML nodes and reported in `File.Diagnostics`.
+	Strict bool
+	// Registry contains the tag definitions. It defaults to the global `schema.DefaultRegistry`.
+	Registry *schema.Registry
+	// Validate checks the tree against the JSON schemas of the tags before converting it.
+	// The error is caused by `schema.ValidationErrors` which carry the paths and the rules.
+	Validate bool
+	// Validator contains the schemas to check. It defaults to the global `schema.Schemas`
+	// which embeds the abridged schemas of the ASDF standard core tags.
+	Validator *schema.Validator
+}
+
+func (options OpenOptions) blockOptions() blockOptions {
+	return blockOptions{maxSize: options.MaxBlockSize, skipChecksums: options.SkipChecksums}
+}
+
 var borderMarks = [][]byte{
 	append([]byte{'.', '.', '.', '\n'}, blockMagic[:]...),
 	append([]byte{'.', '.', '.', '\r', '\n'}, blockMagic[:]...),
@@ -35,133 +88,309 @@ var borderMarks = [][]byte{
 
 // OpenFile reads ASDF from the file system.
 func OpenFile(fileName string, progress ProgressCallback) (*File, error) {
-	reader, err := mmap.Open(fileName)
+	return OpenFileWithOptions(fileName, OpenOptions{Progress: progress})
+}
+
+// OpenFileWithOptions reads ASDF from the file system. The file is mapped into memory. If the file
+// is opened lazily or any array references the mapping because of `OpenOptions.Mmap`, the file
+// stays open until `File.Close()` is called.
+func OpenFileWithOptions(fileName string, options OpenOptions) (*File, error) {
+	return OpenFileContext(context.Background(), fileName, options)
+}
+
+// OpenFileContext is the same as OpenFileWithOptions, but stops reading the blocks after
+// the context is done. The returned error wraps `ctx.Err()`.
+func OpenFileContext(ctx context.Context, fileName string, options OpenOptions) (*File, error) {
+	mapping, err := mapFile(fileName, options.Mmap)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to open %s", fileName)
 	}
-	defer reader.Close()
-	return Open(io.NewSectionReader(reader, 0, int64(reader.Len())), progress)
+	if options.Resolver == nil {
+		options.Resolver = DirectoryResolver(filepath.Dir(fileName))
+	}
+	file, err := OpenContext(ctx, mapping, options)
+	if err != nil {
+		// the arrays may reference the mapping
+		mapping.Close()
+		return nil, err
+	}
+	if !options.Lazy && !mapping.aliased {
+		mapping.Close()
+		return file, nil
+	}
+	file.closer = mapping
+	return file, nil
 }
 
 // Open reads ASDF from a seekable reader.
 func Open(reader io.ReadSeeker, progress ProgressCallback) (*File, error) {
+	return OpenWithOptions(reader, OpenOptions{Progress: progress})
+}
+
+// OpenWithOptions reads ASDF from a seekable reader. If the file is opened lazily, the reader
+// must remain valid while the arrays are being loaded.
+func OpenWithOptions(reader io.ReadSeeker, options OpenOptions) (*File, error) {
+	return OpenContext(context.Background(), reader, options)
+}
+
+// OpenContext is the same as OpenWithOptions, but stops reading the blocks after the context
+// is done. The returned error wraps `ctx.Err()` with the block which was being processed.
+func OpenContext(ctx context.Context, reader io.ReadSeeker, options OpenOptions) (*File, error) {
 	file := &File{}
+	progress := options.Progress
 	if progress == nil {
 		progress = func(_, _ int) {}
 	}
 	progress(0, 2)
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "while reading the header")
+	}
 	var err error
 	file.FormatVersion, file.StandardVersion, err = parseHeader(reader)
 	if err != nil {
 		return nil, err
 	}
+	tracker := newProgressTracker(options.Listener)
+	tracker.Send(ProgressHeaderParsed)
 	tree, blockOffset, err := parseTree(reader)
 	progress(1, 2)
 	if err != nil {
 		return nil, err
 	}
+	if options.Validate {
+		validator := options.Validator
+		if validator == nil {
+			validator = schema.Schemas
+		}
+		if err = validator.Validate(tree); err != nil {
+			return nil, errors.Wrap(err, "invalid tree")
+		}
+	}
 	tag, err := schema.ParseTag(tree.Tag)
 	if err != nil {
 		return nil, errors.Errorf("invalid top level tag: %v", err)
 	}
-	def := schema.FindDefinition(tag)
+	decoder := &schema.Decoder{Registry: options.Registry, Strict: options.Strict}
+	def := decoder.FindDefinition(tag)
 	if def == nil {
 		return nil, errors.Errorf("unknown top level tag: %s", tree.Tag)
 	}
-	doc, err := def.UnmarshalYAML(tree)
+	obj, err := decoder.Unmarshal(def, tree)
 	if err != nil {
 		return nil, err
 	}
-	file.Document = *doc.(*core.Document)
+	doc, ok := obj.(*core.Document)
+	if !ok {
+		return nil, errors.Errorf("the top level tag %s is not a document", tree.Tag)
+	}
+	file.Document = *doc
+	file.Diagnostics = decoder.Diagnostics
 	progress(2, 2)
+	tracker.Send(ProgressTreeParsed)
+	var blocks *blockReader
 	if blockOffset > 0 {
-		if _, err = reader.Seek(int64(blockOffset), io.SeekStart); err != nil {
-			return nil, err
+		blocks = newBlockReader(reader, int64(blockOffset), options.blockOptions())
+		if err = blocks.ReadIndex(); err != nil {
+			return nil, errors.Wrap(err, "while reading the block index")
 		}
-		err = readAndResolveBlocks(&file.Document, reader, progress)
+	}
+	if options.Lazy {
+		deferBlocks(&file.Document, blocks, options)
+	} else {
+		err = readAndResolveBlocks(ctx, &file.Document, blocks, options, progress, tracker)
 	}
 	return file, err
 }
 
-func readAndResolveBlocks(doc *core.Document, reader io.Reader, progress ProgressCallback) error {
+// Close releases the resources held by the file opened with `OpenFile()`. The arrays which have
+// not been loaded yet become inaccessible, and so does `Data` of the arrays which reference
+// the mapped file with `OpenOptions.Mmap`. Copy `Data` beforehand to keep using it. Close does
+// nothing if the file does not hold any resources.
+func (file *File) Close() error {
+	if file.closer == nil {
+		return nil
+	}
+	err := file.closer.Close()
+	file.closer = nil
+	return err
+}
+
+func deferBlocks(doc *core.Document, blocks *blockReader, options OpenOptions) {
+	lazy := &lazyBlocks{
+		reader: blocks, resolver: options.Resolver, options: options.blockOptions(),
+		blocks: map[interface{}]*lazyBlock{},
+	}
+	doc.IterArrays(func(arr *core.NDArray) {
+		if arr.DataType == nil {
+			// the unsupported data type was reported while parsing the tree
+			return
+		}
+		if arr.Source() >= 0 {
+			arr.SetBlockLoader(lazy.Loader(arr.Source()))
+		} else if arr.SourceURI() != "" {
+			arr.SetBlockLoader(lazy.ExternalLoader(arr.SourceURI()))
+		}
+	})
+}
+
+func readAndResolveBlocks(ctx context.Context, doc *core.Document, blocks *blockReader,
+	options OpenOptions, progress ProgressCallback, tracker *progressTracker) error {
 	arrays := map[int][]*core.NDArray{}
-	maxIndex := -1
+	externalArrays := map[string][]*core.NDArray{}
 	doc.IterArrays(func(arr *core.NDArray) {
-		index := int(binary.LittleEndian.Uint32(arr.Data))
-		if index > maxIndex {
-			maxIndex = index
+		if arr.DataType == nil {
+			// the unsupported data type was reported while parsing the tree
+			return
+		}
+		if arr.Source() >= 0 {
+			arrays[arr.Source()] = append(arrays[arr.Source()], arr)
+		} else if arr.SourceURI() != "" {
+			externalArrays[arr.SourceURI()] = append(externalArrays[arr.SourceURI()], arr)
 		}
-		arrays[index] = append(arrays[index], arr)
 	})
-	progress(2, maxIndex+2)
-	steps := 2
-	for i := 0; i < maxIndex; i++ {
-		block, err := ReadBlock(reader)
+	indexes := make([]int, 0, len(arrays))
+	for index := range arrays {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	uris := make([]string, 0, len(externalArrays))
+	for uri := range externalArrays {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	if len(indexes) > 0 && blocks == nil {
+		return errors.Errorf("block #%d does not exist", indexes[0])
+	}
+	sizes := make([]blockSize, len(uris)+len(indexes))
+	// only the headers are read here, the blocks are read and uncompressed one by one below
+	for i, uri := range uris {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "reading the header of the external block %s", uri)
+		}
+		size, err := readExternalSize(options.Resolver, uri, options.blockOptions())
+		if err != nil {
+			return err
+		}
+		sizes[i] = size
+	}
+	for i, index := range indexes {
+		size, err := blocks.ReadSize(index)
 		if err != nil {
-			return errors.Wrapf(err, "reading block #%d", i)
+			return errors.Wrapf(err, "reading the header of block #%d", index)
 		}
-		blockArrays, exist := arrays[i]
-		if !exist {
-			// Orphaned block which is not used by any array
-			continue
+		sizes[len(uris)+i] = size
+	}
+	tracker.Plan(sizes)
+	total := len(indexes) + len(uris) + 2
+	progress(2, total)
+	for i, uri := range uris {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "reading the external block %s", uri)
 		}
-		err = block.Uncompress()
+		tracker.Start(-1, uri)
+		data, err := readExternalBlock(ctx, options.Resolver, uri, options.blockOptions())
 		if err != nil {
-			return errors.Wrapf(err, "uncompressing block #%d", i)
+			return err
 		}
-		for _, arr := range blockArrays {
-			if len(arr.Data) == 4 {
-				arr.Data = block.Data
-				continue
-			}
-			// There is a position
-			offset := int(binary.LittleEndian.Uint32(arr.Data[4:8]))
-			strides := make([]int, (len(arr.Data)-8)/4)
-			if len(strides) == 0 {
-				arr.Data = block.Data[offset : offset+arr.CountBytes()]
-				continue
+		for _, arr := range externalArrays[uri] {
+			if err = arr.AttachBlock(data); err != nil {
+				return errors.Wrapf(err, "resolving the external block %s", uri)
 			}
-			// Construct the new contiguous array from scratch
-			size := 1
-			for j := range strides {
-				stride := int(binary.LittleEndian.Uint32(arr.Data[8+j*4 : 8+(j+1)*4]))
-				strides[j] = stride
-				size *= stride
+		}
+		tracker.Finish(-1, uri, sizes[i])
+		progress(i+3, total)
+	}
+	return resolveBlocks(ctx, blocks, indexes, arrays, options.Concurrency, func(i int, done bool) {
+		if !done {
+			tracker.Start(indexes[i], "")
+			return
+		}
+		tracker.Finish(indexes[i], "", sizes[len(uris)+i])
+		progress(len(uris)+i+3, total)
+	})
+}
+
+// resolveBlocks reads the blocks with the specified indexes sequentially and uncompresses them
+// on `concurrency` goroutines. `report` is called before and after waiting for each block in
+// the order of `indexes`, and the first error in that order is returned.
+func resolveBlocks(ctx context.Context, blocks *blockReader, indexes []int,
+	arrays map[int][]*core.NDArray, concurrency int, report func(i int, done bool)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type job struct {
+		i     int
+		block *Block
+	}
+	results := make([]chan error, len(indexes))
+	for i := range results {
+		results[i] = make(chan error, 1)
+	}
+	jobs := make(chan job, concurrency)
+	stop := make(chan struct{})
+	workers := sync.WaitGroup{}
+	workers.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				results[j.i] <- resolveBlock(ctx, j.block, indexes[j.i], arrays[indexes[j.i]],
+					blocks.options)
 			}
-			arr.Data = make([]byte, arr.CountBytes())
-			chunkSize := arr.Shape[len(arr.Shape)-1] * arr.ElementSize()
-			if len(arr.Shape) == 1 {
-				copy(arr.Data, block.Data[offset:offset+chunkSize])
-				continue
+		}()
+	}
+	go func() {
+		// the workers exit after jobs are closed, so waiting for them waits for us as well
+		defer close(jobs)
+		for i, index := range indexes {
+			if ctx.Err() != nil {
+				results[i] <- errors.Wrapf(ctx.Err(), "reading block #%d", index)
+				return
 			}
-			lastStride := strides[len(strides)-1] * arr.ElementSize()
-			balance := make([]int, len(arr.Shape)-1)
-			offsets := make([]int, len(arr.Shape)-1)
-			for j := 0; j < len(arr.Shape)-1; j++ {
-				balance[j] = arr.Shape[j]
-				offsets[j] = offset
+			block, err := blocks.ReadBlock(index)
+			if err != nil {
+				results[i] <- errors.Wrapf(err, "reading block #%d", index)
+				return
 			}
-			arrOffset := 0
-			for focus := 0; focus >= 0; {
-				copy(arr.Data[arrOffset:arrOffset+chunkSize], block.Data[offset:offset+chunkSize])
-				arrOffset += chunkSize
-				offset += lastStride
-				for focus = len(arr.Shape) - 2; focus >= 0; {
-					balance[focus]--
-					if balance[focus] == 0 {
-						balance[focus] = arr.Shape[focus]
-						offset = offsets[focus] + strides[focus]
-						for dim := focus; dim < len(arr.Shape)-1; dim++ {
-							offsets[dim] = offset
-						}
-						focus--
-					} else {
-						break
-					}
-				}
+			select {
+			case jobs <- job{i, block}:
+			case <-stop:
+				return
 			}
 		}
-		progress(steps+i+1, len(arrays)+2)
+	}()
+	var err error
+	for i, index := range indexes {
+		if ctx.Err() != nil {
+			err = errors.Wrapf(ctx.Err(), "resolving block #%d", index)
+			break
+		}
+		report(i, false)
+		if err = <-results[i]; err != nil {
+			break
+		}
+		report(i, true)
+	}
+	close(stop)
+	workers.Wait()
+	return err
+}
+
+// resolveBlock uncompresses the block and assigns it to the referencing arrays.
+func resolveBlock(ctx context.Context, block *Block, index int, arrays []*core.NDArray,
+	options blockOptions) error {
+	if err := block.uncompress(ctx, options); err != nil {
+		return errors.Wrapf(err, "uncompressing block #%d", index)
+	}
+	for _, arr := range arrays {
+		if err := arr.AttachBlock(block.Data); err != nil {
+			return errors.Wrapf(err, "resolving block #%d", index)
+		}
 	}
 	return nil
 }
@@ -2,9 +2,27 @@ package asdf
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
+	"unsafe"
 
+	"github.com/Jeffail/gabs/v2"
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/src-d/go-asdf/schema"
+	"github.com/src-d/go-asdf/schema/core"
 )
 
 func TestOpenFile(t *testing.T) {
@@ -54,3 +72,1100 @@ func TestInlineArray(t *testing.T) {
 	req.NoError(err)
 	req.NotNil(asdfFile)
 }
+
+func TestInlineArrayDataTypes(t *testing.T) {
+	req := require.New(t)
+	source := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+bools: !core/ndarray-1.0.0
+  data: [[true, false], [False, TRUE]]
+halves: !core/ndarray-1.0.0
+  data: [0.5, -65504, 1e-7, .inf, -.inf, .nan, 70000, 0x10]
+  datatype: float16
+signed: !core/ndarray-1.0.0
+  data: [-128, 127, -1]
+  datatype: int8
+huge: !core/ndarray-1.0.0
+  data: [18446744073709551615, 0]
+  datatype: uint64
+bases: !core/ndarray-1.0.0
+  data: [010, +5, 0x1F, 0o17, 0xFFFFFFFFFFFFFFFF]
+  datatype: uint64
+inferred: !core/ndarray-1.0.0
+  data: [1, -2.5]
+ints: !core/ndarray-1.0.0
+  data: [1, 2, -3]
+names: !core/ndarray-1.0.0
+  data: [abc, "d\u00e9"]
+codes: !core/ndarray-1.0.0
+  data: [[ab], [c]]
+  datatype: [ascii, 2]
+...
+`)
+	file, err := Open(bytes.NewReader(source), nil)
+	req.NoError(err)
+	arr := func(name string) *core.NDArray {
+		return file.Tree.Path(name).Data().(*core.NDArray)
+	}
+	req.Equal(types.Typ[types.Bool], arr("bools").DataType)
+	bools, err := arr("bools").Bools()
+	req.NoError(err)
+	req.Equal([]bool{true, false, false, true}, bools)
+	req.Same(core.Float16, arr("halves").DataType)
+	req.Equal("array<float16, LittleEndian> of shape [8]", arr("halves").String())
+	halves, err := arr("halves").Float16s()
+	req.NoError(err)
+	req.Equal([]float32{0.5, -65504, 1.1920929e-07, float32(math.Inf(1)),
+		float32(math.Inf(-1))}, halves[:5])
+	req.True(math.IsNaN(float64(halves[5])))
+	req.Equal([]float32{float32(math.Inf(1)), 16}, halves[6:])
+	_, err = arr("halves").Uint16s()
+	req.Error(err)
+	value, err := arr("halves").At(0)
+	req.NoError(err)
+	req.Equal(float32(0.5), value)
+	req.NoError(arr("halves").Set(3, 0))
+	value, err = arr("halves").At(0)
+	req.NoError(err)
+	req.Equal(float32(3), value)
+	signed, err := arr("signed").Int8s()
+	req.NoError(err)
+	req.Equal([]int8{-128, 127, -1}, signed)
+	huge, err := arr("huge").Uint64s()
+	req.NoError(err)
+	req.Equal([]uint64{math.MaxUint64, 0}, huge)
+	bases, err := arr("bases").Uint64s()
+	req.NoError(err)
+	req.Equal([]uint64{10, 5, 31, 15, math.MaxUint64}, bases)
+	inferred, err := arr("inferred").Float64s()
+	req.NoError(err)
+	req.Equal([]float64{1, -2.5}, inferred)
+	req.Equal(types.Typ[types.Int64], arr("ints").DataType)
+	req.Equal(reflect.TypeOf(int64(0)), arr("ints").ReflectedDataType())
+	ints, err := arr("ints").Int64s()
+	req.NoError(err)
+	req.Equal([]int64{1, 2, -3}, ints)
+	req.Equal("array<[ucs4, 3], LittleEndian> of shape [2]", arr("names").String())
+	names, err := arr("names").Strings()
+	req.NoError(err)
+	req.Equal([]string{"abc", "d\u00e9"}, names)
+	req.Equal(core.ASCII, arr("codes").DataType)
+	codes, err := arr("codes").Strings()
+	req.NoError(err)
+	req.Equal([]string{"ab", "c"}, codes)
+
+	buffer := &bytes.Buffer{}
+	_, err = file.WriteTo(buffer)
+	req.NoError(err)
+	req.Contains(buffer.String(), "data: [[true, false], [false, true]]\n  datatype: bool8")
+	req.Contains(buffer.String(), "datatype: float16")
+	req.Contains(buffer.String(), "data: [1, 2, -3]\n  datatype: int64")
+	req.Contains(buffer.String(), "data: [abc, d\u00e9]\n  datatype: [ucs4, 3]")
+	req.Contains(buffer.String(), "data: [[ab], [c]]\n  datatype: [ascii, 2]")
+	file, err = Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	halves, err = arr("halves").Float16s()
+	req.NoError(err)
+	req.Equal(float32(3), halves[0])
+	req.Equal(float32(1.1920929e-07), halves[2])
+
+	for _, data := range []string{"[128]", "[1.5]", "[true]", "[abc]", "[[1], 2]", "[{a: 1}]",
+		"[1_0]", "[0b1]", "[-0x1]", "[0o8]", "[0x1p-2]"} {
+		_, err = Open(bytes.NewReader(bytes.Replace(source, []byte("[-128, 127, -1]"),
+			[]byte(data), 1)), nil)
+		req.Error(err, data)
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	req := require.New(t)
+	for bits, value := range map[uint16]float32{
+		0x0000: 0, 0x8000: float32(math.Copysign(0, -1)), 0x3c00: 1, 0xc000: -2,
+		0x7bff: 65504, 0x0001: 5.9604645e-08, 0x03ff: 6.0975552e-05, 0x0400: 6.1035156e-05,
+		0x3555: 0.33325195, 0x7c00: float32(math.Inf(1)), 0xfc00: float32(math.Inf(-1)),
+	} {
+		req.Equal(math.Float32bits(value), math.Float32bits(core.Float16ToFloat32(bits)),
+			"%x", bits)
+		req.Equal(bits, core.Float32ToFloat16(value), "%v", value)
+	}
+	req.True(math.IsNaN(float64(core.Float16ToFloat32(0x7e00))))
+	req.Equal(uint16(0x7e00), core.Float32ToFloat16(float32(math.NaN()))&0x7e00)
+	// rounding to the nearest even
+	req.Equal(uint16(0x3c00), core.Float32ToFloat16(1+1.0/2048))
+	req.Equal(uint16(0x3c02), core.Float32ToFloat16(1+3.0/2048))
+	req.Equal(uint16(0x7c00), core.Float32ToFloat16(65520))
+	req.Equal(uint16(0x7bff), core.Float32ToFloat16(65519))
+	req.Equal(uint16(0x0000), core.Float32ToFloat16(2.9802322e-08))
+	req.Equal(uint16(0x0001), core.Float32ToFloat16(2.9802326e-08))
+}
+
+// trackingReader remembers which bytes were read.
+type trackingReader struct {
+	*bytes.Reader
+	touched []bool
+}
+
+func (tr *trackingReader) Read(p []byte) (int, error) {
+	pos := int(tr.Size()) - tr.Len()
+	n, err := tr.Reader.Read(p)
+	for i := pos; i < pos+n; i++ {
+		tr.touched[i] = true
+	}
+	return n, err
+}
+
+func TestOpenLazy(t *testing.T) {
+	req := require.New(t)
+	eager, err := OpenFile("testdata/default.asdf", nil)
+	req.NoError(err)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	reader := &trackingReader{bytes.NewReader(contents), make([]bool, len(contents))}
+	lazy, err := OpenWithOptions(reader, OpenOptions{Lazy: true})
+	req.NoError(err)
+	// the small file is read completely while searching for the first block
+	reader.touched = make([]bool, len(contents))
+	lazy.IterArrays(func(arr *core.NDArray) {
+		req.Nil(arr.Data)
+	})
+	arr := lazy.Tree.Path("arrs.2").Data().(*core.NDArray)
+	req.Equal(3, arr.Source())
+	view, err := arr.Squeeze()
+	req.NoError(err)
+	req.Equal(-1, view.Source())
+	req.NoError(arr.Load())
+	req.Equal(eager.Tree.Path("arrs.2").Data().(*core.NDArray).Data, arr.Data)
+	// block #0 is zlib-compressed, its payload starts after the header
+	firstPayload := bytes.Index(contents, blockMagic[:]) + 6 + blockHeaderSize
+	req.False(reader.touched[firstPayload])
+	req.Nil(lazy.Tree.Path("one.four.five").Data().(*core.NDArray).Data)
+	for _, path := range []string{"arrs.0", "arrs.1", "arrs.2", "one.four.five"} {
+		arr := lazy.Tree.Path(path).Data().(*core.NDArray)
+		req.NoError(arr.Load())
+		req.NoError(arr.Load())
+		req.Equal(eager.Tree.Path(path).Data().(*core.NDArray).Data, arr.Data, path)
+	}
+	req.NoError(lazy.Close())
+}
+
+func TestOpenFileLazy(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFileWithOptions("testdata/standard/compressed.asdf", OpenOptions{Lazy: true})
+	req.NoError(err)
+	arr := file.Tree.Path("zlib").Data().(*core.NDArray)
+	req.Nil(arr.Data)
+	req.NoError(arr.Load())
+	req.Len(arr.Data, 128*8)
+	req.NoError(file.Close())
+	arr = file.Tree.Path("bzp2").Data().(*core.NDArray)
+	req.Error(arr.Load())
+}
+
+func TestBlockIndex(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	blocks := newBlockReader(bytes.NewReader(contents), 846, blockOptions{})
+	req.NoError(blocks.ReadIndex())
+	req.Equal([]int64{846, 927, 1010, 1108}, blocks.offsets)
+	block, err := blocks.ReadBlock(3)
+	req.NoError(err)
+	req.Len(block.Data, 80)
+
+	// the block index of shared.asdf is inconsistent: 467 instead of 463
+	contents, err = ioutil.ReadFile("testdata/standard/shared.asdf")
+	req.NoError(err)
+	blocks = newBlockReader(bytes.NewReader(contents), 463, blockOptions{})
+	req.NoError(blocks.ReadIndex())
+	req.Equal([]int64{463}, blocks.offsets)
+}
+
+func TestBlockIndexFallback(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	eager, err := Open(bytes.NewReader(contents), nil)
+	req.NoError(err)
+	indexPos := bytes.Index(contents, []byte(blockIndexHeader))
+	for _, index := range []string{
+		"[846, 927, 1010, 1109]",
+		"[846, 1010, 927, 1108]",
+		"[846, 927, 1010]",
+		"[846, 927, 1010, 1108, 1200]",
+		"[846, 927, 1010, 1108",
+		"{}",
+	} {
+		corrupted := append(contents[:indexPos:indexPos],
+			[]byte(blockIndexHeader+"\n%YAML 1.1\n--- "+index+"\n...\n")...)
+		blocks := newBlockReader(bytes.NewReader(corrupted), 846, blockOptions{})
+		req.NoError(blocks.ReadIndex(), index)
+		if index == "[846, 927, 1010]" {
+			// valid but incomplete
+			req.Len(blocks.offsets, 3)
+		} else {
+			req.Len(blocks.offsets, 1, index)
+		}
+		file, err := Open(bytes.NewReader(corrupted), nil)
+		req.NoError(err, index)
+		req.Equal(eager.Tree.Path("arrs.2").Data().(*core.NDArray).Data,
+			file.Tree.Path("arrs.2").Data().(*core.NDArray).Data, index)
+	}
+	// no index at all
+	file, err := Open(bytes.NewReader(contents[:indexPos]), nil)
+	req.NoError(err)
+	req.Equal(eager.Tree.Path("arrs.2").Data().(*core.NDArray).Data,
+		file.Tree.Path("arrs.2").Data().(*core.NDArray).Data)
+}
+
+func makeStreamedFile(req *require.Assertions, rows []byte) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+fixed: !core/ndarray-1.0.0
+  source: 0
+  datatype: uint8
+  byteorder: little
+  shape: [3]
+stream: !core/ndarray-1.0.0
+  source: 1
+  datatype: int16
+  byteorder: big
+  shape: ['*', 3]
+...
+`)
+	req.NoError(WriteBlock(buffer, &Block{Data: []byte{1, 2, 3}}))
+	req.NoError(WriteBlock(buffer, &Block{Data: rows, Flags: FlagStreamed}))
+	return buffer.Bytes()
+}
+
+func TestOpenStreamed(t *testing.T) {
+	req := require.New(t)
+	rows := make([]byte, 4*6+5)
+	for i := 0; i < len(rows)/2; i++ {
+		binary.BigEndian.PutUint16(rows[i*2:], uint16(i))
+	}
+	contents := makeStreamedFile(req, rows)
+	for _, lazy := range []bool{false, true} {
+		file, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{Lazy: lazy})
+		req.NoError(err)
+		arr := file.Tree.Path("stream").Data().(*core.NDArray)
+		req.NoError(arr.Load())
+		req.Equal([]int{4, 3}, arr.Shape)
+		req.Equal(rows[:4*6], arr.Data)
+		fixed := file.Tree.Path("fixed").Data().(*core.NDArray)
+		req.NoError(fixed.Load())
+		req.Equal([]byte{1, 2, 3}, fixed.Data)
+	}
+	file, err := OpenWithOptions(bytes.NewReader(makeStreamedFile(req, nil)), OpenOptions{Lazy: true})
+	req.NoError(err)
+	arr := file.Tree.Path("stream").Data().(*core.NDArray)
+	req.Equal("array<int16, BigEndian> of shape [*, 3]", arr.String())
+	req.NoError(arr.Load())
+	req.Equal([]int{0, 3}, arr.Shape)
+}
+
+func TestOpenStreamedNotLast(t *testing.T) {
+	req := require.New(t)
+	contents := makeStreamedFile(req, make([]byte, 60))
+	blocks := newBlockReader(bytes.NewReader(contents), int64(bytes.Index(contents, blockMagic[:])),
+		blockOptions{})
+	_, err := blocks.ReadBlock(2)
+	req.Error(err)
+	block, err := blocks.ReadBlock(1)
+	req.NoError(err)
+	req.Equal(FlagStreamed, block.Flags)
+	req.Len(block.Data, 60)
+}
+
+func TestOpenViews(t *testing.T) {
+	req := require.New(t)
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+base: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, shape: [3, 4]}
+contiguous: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, shape: [2, 4],
+  offset: 8, strides: [8, 2]}
+transposed: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, shape: [4, 3],
+  strides: [2, 8]}
+reversed: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, shape: [3, 2],
+  offset: 22, strides: [-8, -4]}
+windows: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, shape: [3, 3],
+  offset: 2, strides: [2, 2]}
+...
+`)
+	block := make([]byte, 24)
+	for i := 0; i < 12; i++ {
+		binary.BigEndian.PutUint16(block[i*2:], uint16(i))
+	}
+	req.NoError(WriteBlock(buffer, &Block{Data: block}))
+	contents := buffer.Bytes()
+	uint16s := func(arr *core.NDArray) []uint16 {
+		values := make([]uint16, len(arr.Data)/2)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(arr.Data[i*2:])
+		}
+		return values
+	}
+	for _, lazy := range []bool{false, true} {
+		file, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{Lazy: lazy})
+		req.NoError(err)
+		expected := map[string][]uint16{
+			"base":       {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			"contiguous": {4, 5, 6, 7, 8, 9, 10, 11},
+			"transposed": {0, 4, 8, 1, 5, 9, 2, 6, 10, 3, 7, 11},
+			"reversed":   {11, 9, 7, 5, 3, 1},
+			"windows":    {1, 2, 3, 2, 3, 4, 3, 4, 5},
+		}
+		for name, values := range expected {
+			arr := file.Tree.Path(name).Data().(*core.NDArray)
+			req.NoError(arr.Load(), name)
+			req.Equal(values, uint16s(arr), name)
+		}
+	}
+}
+
+func TestOpenViewOutOfBounds(t *testing.T) {
+	req := require.New(t)
+	for _, view := range []string{
+		"shape: [4], offset: 18, strides: [2]",
+		"shape: [4], offset: 4, strides: [-2]",
+		"shape: [2, 2], strides: [2]",
+		"shape: [4], strides: [0]",
+		"shape: [4], offset: 30",
+	} {
+		buffer := &bytes.Buffer{}
+		buffer.WriteString(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+view: !core/ndarray-1.0.0 {source: 0, datatype: uint16, byteorder: big, ` + view + `}
+...
+`)
+		req.NoError(WriteBlock(buffer, &Block{Data: make([]byte, 24)}))
+		_, err := Open(bytes.NewReader(buffer.Bytes()), nil)
+		req.Error(err, view)
+	}
+}
+
+func TestOpenFileZeroCopy(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFileWithOptions("testdata/standard/shared.asdf", OpenOptions{Mmap: true})
+	req.NoError(err)
+	mapping, ok := file.closer.(*mappedFile)
+	req.True(ok)
+	if mapping.data == nil {
+		t.Skip("zero-copy is not supported on this platform")
+	}
+	start := uintptr(unsafe.Pointer(&mapping.data[0]))
+	arr := file.Tree.Path("data").Data().(*core.NDArray)
+	pos := uintptr(unsafe.Pointer(&arr.Data[0]))
+	req.True(pos >= start && pos < start+uintptr(len(mapping.data)))
+	// copy-on-write
+	arr.Data[0] = 0xff
+	req.Equal(uint64(0xff), binary.LittleEndian.Uint64(arr.Data))
+	req.NoError(file.Close())
+	req.Nil(file.closer)
+	req.NoError(file.Close())
+	contents, err := ioutil.ReadFile("testdata/standard/shared.asdf")
+	req.NoError(err)
+	req.NotContains(string(contents), "\xff\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	// the mapping is released on error, so the partly read file must not be returned
+	file, err = OpenFileWithOptions("testdata/standard/shared.asdf", OpenOptions{
+		Mmap: true, MaxBlockSize: 63,
+	})
+	req.Error(err)
+	req.Nil(file)
+
+	// all the blocks are compressed
+	file, err = OpenFile("testdata/standard/compressed.asdf", nil)
+	req.NoError(err)
+	req.Nil(file.closer)
+}
+
+func TestOpenConcurrency(t *testing.T) {
+	req := require.New(t)
+	for _, name := range []string{
+		"testdata/default.asdf", "testdata/standard/compressed.asdf", "testdata/standard/shared.asdf",
+	} {
+		contents, err := ioutil.ReadFile(name)
+		req.NoError(err)
+		var sequentialProgress [][2]int
+		sequential, err := Open(bytes.NewReader(contents), func(done, total int) {
+			sequentialProgress = append(sequentialProgress, [2]int{done, total})
+		})
+		req.NoError(err)
+		for _, concurrency := range []int{2, 4, 16} {
+			var parallelProgress [][2]int
+			parallel, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+				Concurrency: concurrency,
+				Progress: func(done, total int) {
+					parallelProgress = append(parallelProgress, [2]int{done, total})
+				},
+			})
+			req.NoError(err)
+			req.Equal(sequentialProgress, parallelProgress)
+			req.Equal(sequential.Tree.String(), parallel.Tree.String())
+		}
+	}
+}
+
+func TestOpenConcurrencyError(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	// corrupt the checksums of blocks #1 and #2
+	for _, offset := range []int{927, 1010} {
+		contents[offset+38] ^= 0xff
+	}
+	for _, concurrency := range []int{0, 1, 2, 8} {
+		for i := 0; i < 10; i++ {
+			_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Concurrency: concurrency})
+			req.Error(err)
+			req.Contains(err.Error(), "block #1")
+		}
+	}
+}
+
+func TestOpenContext(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := OpenFileContext(ctx, "testdata/default.asdf", OpenOptions{})
+	req.Equal(context.Canceled, errors.Cause(err))
+	for _, concurrency := range []int{0, 4} {
+		ctx, cancel = context.WithCancel(context.Background())
+		_, err = OpenFileContext(ctx, "testdata/default.asdf", OpenOptions{
+			Concurrency: concurrency,
+			Progress: func(done, total int) {
+				if done == 3 {
+					cancel()
+				}
+			},
+		})
+		req.Equal(context.Canceled, errors.Cause(err))
+		req.Contains(err.Error(), "block #1")
+	}
+	file, err := OpenFileContext(context.Background(), "testdata/default.asdf", OpenOptions{})
+	req.NoError(err)
+	req.NoError(file.Close())
+}
+
+func TestOpenSkipChecksums(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	contents[927+38] ^= 0xff
+	_, err = Open(bytes.NewReader(contents), nil)
+	req.Error(err)
+	for _, lazy := range []bool{false, true} {
+		file, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+			SkipChecksums: true, Lazy: lazy,
+		})
+		req.NoError(err)
+		file.IterArrays(func(arr *core.NDArray) {
+			req.NoError(arr.Load())
+		})
+	}
+}
+
+func TestOpenMaxBlockSize(t *testing.T) {
+	req := require.New(t)
+	// block #0 is 4000 bytes uncompressed
+	_, err := OpenFileWithOptions("testdata/default.asdf", OpenOptions{MaxBlockSize: 3999})
+	req.Error(err)
+	req.Contains(err.Error(), "too big")
+	_, err = OpenFileWithOptions("testdata/default.asdf", OpenOptions{MaxBlockSize: 4000})
+	req.NoError(err)
+	file, err := OpenFileWithOptions("testdata/default.asdf", OpenOptions{
+		MaxBlockSize: 100, Lazy: true,
+	})
+	req.NoError(err)
+	req.Error(file.Tree.Path("one.four.five").Data().(*core.NDArray).Load())
+	req.NoError(file.Close())
+	// uncompressed blocks
+	_, err = OpenFileWithOptions("testdata/standard/shared.asdf", OpenOptions{MaxBlockSize: 63})
+	req.Error(err)
+	file, err = OpenFileWithOptions("testdata/standard/shared.asdf", OpenOptions{MaxBlockSize: 64})
+	req.NoError(err)
+	req.NoError(file.Close())
+}
+
+type testDefinition struct {
+}
+
+func (td testDefinition) Version() semver.Version {
+	return semver.MustParse("1.0.0")
+}
+
+func (td testDefinition) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return "test:" + value.Value, nil
+}
+
+func TestOpenStrictAndRegistry(t *testing.T) {
+	req := require.New(t)
+	contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+custom: !<tag:example.com:custom-1.0.0> value
+...
+`)
+	file, err := Open(bytes.NewReader(contents), nil)
+	req.NoError(err)
+	custom := file.Tree.Path("custom").Data().(schema.TaggedValue)
+	req.Equal("tag:example.com:custom-1.0.0", custom.Tag.String())
+	req.Equal("value", custom.Node.Value)
+	req.Len(file.Diagnostics, 1)
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Strict: true})
+	req.Error(err)
+	req.Contains(err.Error(), "example.com:custom-1.0.0")
+
+	registry := schema.DefaultRegistry.Clone()
+	req.NoError(registry.Register("tag:example.com:custom", testDefinition{}))
+	for _, strict := range []bool{false, true} {
+		file, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+			Registry: registry, Strict: strict,
+		})
+		req.NoError(err)
+		req.Equal("test:value", file.Tree.Path("custom").Data())
+	}
+	req.Empty(schema.DefaultRegistry.Versions("tag:example.com:custom"))
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Registry: schema.NewRegistry()})
+	req.Error(err)
+	req.Contains(err.Error(), "unknown top level tag")
+}
+
+func TestOpenTagDirectives(t *testing.T) {
+	req := require.New(t)
+	contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.6.0
+%YAML 1.1
+%TAG !core! asdf://asdf-format.org/core/tags/
+%TAG !ex! tag:example-org.com:custom/
+--- !<tag:stsci.edu:asdf/core/asdf-1.1.0>
+asdf_library: !core!software-1.0.0 {name: asdf, version: 3.0.0}
+history:
+  entries:
+  - !core!history_entry-1.0.0
+    description: created
+    software: !core!software-1.0.0 {name: asdf, version: 3.0.0}
+arr: !<tag:stsci.edu:asdf/core/ndarray-1.0.0> {data: [1, 2, 3], datatype: uint8}
+custom: !ex!thing-1.0.0 value
+...
+`)
+	registry := schema.DefaultRegistry.Clone()
+	req.NoError(registry.Register("tag:example-org.com:custom/thing", testDefinition{}))
+	file, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{Registry: registry})
+	req.NoError(err)
+	req.Equal("test:value", file.Tree.Path("custom").Data())
+	arr := file.Tree.Path("arr").Data().(*core.NDArray)
+	req.Equal([]int{3}, arr.Shape)
+	req.Equal("asdf", file.Library.Name)
+	req.Equal("asdf", file.History.Entries[0].Software[0].Name)
+	// the tags published by ASDF standard 1.6 match exactly
+	req.Empty(file.Diagnostics)
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Strict: true, Validate: true,
+		Registry: registry})
+	req.NoError(err)
+	// go-asdf implements ndarray-1.0.0 which asdf-format.org never published
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		file, err = Open(bytes.NewReader(bytes.Replace(contents,
+			[]byte("!<tag:stsci.edu:asdf/core/ndarray-1.0.0>"),
+			[]byte("!core!ndarray-"+version), 1)), nil)
+		req.NoError(err)
+		req.Len(file.Diagnostics, 2)
+		req.Equal("arr: unsupported tag: asdf://asdf-format.org/core/tags/ndarray-"+version,
+			file.Diagnostics[0].String())
+	}
+}
+
+func TestOpenProgressEvents(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	var stored, uncompressed uint64
+	for _, offset := range []int{846, 927, 1010, 1108} {
+		stored += binary.BigEndian.Uint64(contents[offset+22:])
+		uncompressed += binary.BigEndian.Uint64(contents[offset+30:])
+	}
+	var sequential []ProgressEvent
+	for _, concurrency := range []int{0, 4} {
+		var events []ProgressEvent
+		_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+			Concurrency: concurrency,
+			Listener: func(event ProgressEvent) {
+				events = append(events, event)
+			},
+		})
+		req.NoError(err)
+		req.Len(events, 10)
+		req.Equal(ProgressHeaderParsed, events[0].Kind)
+		req.Equal(ProgressTreeParsed, events[1].Kind)
+		for i, event := range events[2:] {
+			req.Equal(i/2, event.Block)
+			req.Equal(stored, event.TotalBytesRead)
+			req.Equal(uncompressed, event.TotalBytesUncompressed)
+			if i%2 == 0 {
+				req.Equal(ProgressBlockStarted, event.Kind)
+			} else {
+				req.Equal(ProgressBlockFinished, event.Kind)
+				req.True(event.BytesRead > events[i+1].BytesRead)
+			}
+		}
+		last := events[len(events)-1]
+		req.Equal(stored, last.BytesRead)
+		req.Equal(uncompressed, last.BytesUncompressed)
+		if sequential == nil {
+			sequential = events
+		} else {
+			req.Equal(sequential, events)
+		}
+	}
+}
+
+func TestOpenProgressEventsStreamedAndExternal(t *testing.T) {
+	req := require.New(t)
+	var last ProgressEvent
+	_, err := OpenWithOptions(bytes.NewReader(makeStreamedFile(req, make([]byte, 13))), OpenOptions{
+		Listener: func(event ProgressEvent) {
+			last = event
+		},
+	})
+	req.NoError(err)
+	req.Equal(uint64(3+13), last.BytesRead)
+	req.Equal(uint64(3+13), last.TotalBytesRead)
+	req.Equal(last.BytesRead, last.BytesUncompressed)
+
+	var events []ProgressEvent
+	opened := map[string]int{}
+	// the number of events sent before each open
+	var openedAfter []int
+	resolver := DirectoryResolver("testdata/standard")
+	_, err = OpenFileWithOptions("testdata/standard/exploded.asdf", OpenOptions{
+		Listener: func(event ProgressEvent) {
+			events = append(events, event)
+		},
+		Resolver: func(uri string) (io.ReadSeeker, error) {
+			opened[uri]++
+			openedAfter = append(openedAfter, len(events))
+			return resolver(uri)
+		},
+	})
+	req.NoError(err)
+	// the header is read before the totals are known, the block between its events
+	req.Equal(map[string]int{"exploded0000.asdf": 2}, opened)
+	req.Equal([]int{2, 3}, openedAfter)
+	req.Len(events, 4)
+	req.Equal(-1, events[3].Block)
+	req.Equal("exploded0000.asdf", events[3].URI)
+	req.Equal(uint64(64), events[3].BytesUncompressed)
+	req.Equal(uint64(64), events[3].TotalBytesUncompressed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opened = map[string]int{}
+	_, err = OpenFileContext(ctx, "testdata/standard/exploded.asdf", OpenOptions{
+		Resolver: func(uri string) (io.ReadSeeker, error) {
+			opened[uri]++
+			cancel()
+			return resolver(uri)
+		},
+	})
+	req.Equal(context.Canceled, errors.Cause(err))
+	req.Equal(map[string]int{"exploded0000.asdf": 1}, opened)
+}
+
+// lenientProblems maps the top level keys to the lines with and without an unsupported
+// tag, key or data type.
+var lenientProblems = []struct {
+	key, problem, clean string
+}{
+	{"asdf_library",
+		"asdf_library: !core/software-1.0.0 {name: test, version: 1.0.0, license: MIT}",
+		"asdf_library: !core/software-1.0.0 {name: test, version: 1.0.0}"},
+	{"history",
+		"history:\n  entries:\n  - !core/history_entry-1.0.0 {description: created, reviewer: me}",
+		"history:\n  entries:\n  - !core/history_entry-1.0.0 {description: created}"},
+	{"arr",
+		"arr: !core/ndarray-1.0.0 {source: 0, datatype: float128, byteorder: little, shape: [2],\n" +
+			"  mask: 0}",
+		"arr: !core/ndarray-1.0.0 {source: 0, datatype: uint8, byteorder: little, shape: [2]}"},
+	{"custom",
+		"custom: !<tag:example.com:custom-1.0.0> {x: 1}",
+		"custom: {x: 1}"},
+}
+
+// makeLenientFile generates the file with the unsupported data at the specified top level keys.
+func makeLenientFile(req *require.Assertions, keys ...string) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+`)
+	for _, problem := range lenientProblems {
+		line := problem.clean
+		for _, key := range keys {
+			if key == problem.key {
+				line = problem.problem
+			}
+		}
+		buffer.WriteString(line + "\n")
+	}
+	buffer.WriteString("...\n")
+	req.NoError(WriteBlock(buffer, &Block{Data: []byte{1, 2}}))
+	return buffer.Bytes()
+}
+
+func TestOpenLenient(t *testing.T) {
+	req := require.New(t)
+	file, err := Open(bytes.NewReader(
+		makeLenientFile(req, "asdf_library", "history", "arr", "custom")), nil)
+	req.NoError(err)
+	var diags []string
+	for _, diag := range file.Diagnostics {
+		diags = append(diags, diag.String())
+	}
+	sort.Strings(diags)
+	req.Equal([]string{
+		"arr: unknown property of core/ndarray-1.0.0: mask",
+		"arr: unsupported dtype of core/ndarray-1.0.0: float128 - the data is not loaded",
+		"asdf_library: unknown property of core/software-1.0.0: license",
+		"custom: unsupported tag: tag:example.com:custom-1.0.0",
+		"history: unknown property of core/history_entry-1.0.0: reviewer",
+	}, diags)
+	req.Equal("MIT", file.Library.Extra["license"].Value)
+	req.Equal("me", file.History.Entries[0].Extra["reviewer"].Value)
+	arr := file.Tree.Path("arr").Data().(*core.NDArray)
+	req.Nil(arr.DataType)
+	req.Nil(arr.Data)
+	req.Equal("float128", arr.Extra["datatype"].Value)
+	req.Equal("0", arr.Extra["mask"].Value)
+	req.Equal("array<float128, LittleEndian> of shape [2]", arr.String())
+	// the block would be corrupted
+	_, err = file.WriteTo(&bytes.Buffer{})
+	req.EqualError(err, "while serializing core/asdf-1.1.0: while converting arr: "+
+		"array<float128, LittleEndian> of shape [2]: cannot write the unsupported data type float128")
+
+	// the other kept nodes are written back
+	req.NoError(file.Tree.Delete("arr"))
+	buffer := &bytes.Buffer{}
+	_, err = file.WriteTo(buffer)
+	req.NoError(err)
+	written := buffer.String()
+	for _, text := range []string{
+		"license: MIT", "reviewer: me", "custom: !<tag:example.com:custom-1.0.0>",
+	} {
+		req.Contains(written, text)
+	}
+	file, err = Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	req.Len(file.Diagnostics, 3)
+
+	// lazy loading skips the array, too
+	file, err = OpenWithOptions(bytes.NewReader(makeLenientFile(req, "arr")),
+		OpenOptions{Lazy: true})
+	req.NoError(err)
+	arr = file.Tree.Path("arr").Data().(*core.NDArray)
+	req.NoError(arr.Load())
+	req.Nil(arr.Data)
+}
+
+func TestOpenLenientLocalTag(t *testing.T) {
+	req := require.New(t)
+	contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+x: !<!local-1.0.0> value
+...
+`)
+	file, err := Open(bytes.NewReader(contents), nil)
+	req.NoError(err)
+	req.Len(file.Diagnostics, 1)
+	req.Equal(`x: cannot parse tag: "!local-1.0.0": not a URI, is %TAG missing?`,
+		file.Diagnostics[0].String())
+	value := file.Tree.Path("x").Data().(schema.TaggedValue)
+	req.Equal("!local-1.0.0", value.Tag.Name)
+	req.Equal("value", value.Node.Value)
+	buffer := &bytes.Buffer{}
+	_, err = file.WriteTo(buffer)
+	req.NoError(err)
+	req.Contains(buffer.String(), "x: !local-1.0.0 value")
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Strict: true})
+	req.Error(err)
+}
+
+func TestOpenLenientLibrary(t *testing.T) {
+	req := require.New(t)
+	for _, problem := range []struct {
+		library, tag, message string
+	}{
+		{"!core/software-9.0.0 {name: test, version: 1.0.0}", "core/software-9.0.0",
+			"unsupported tag: tag:stsci.edu:asdf/core/software-9.0.0"},
+		{"!core/ndarray-1.0.0 {data: [1, 2]}", "core/ndarray-1.0.0",
+			"expected core/software, got tag:stsci.edu:asdf/core/ndarray-1.0.0"},
+	} {
+		library, message := problem.library, problem.message
+		contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+asdf_library: ` + library + `
+history:
+  entries:
+  - !core/history_entry-1.0.0
+    description: created
+    software: ` + library + `
+...
+`)
+		file, err := Open(bytes.NewReader(contents), nil)
+		req.NoError(err, library)
+		req.Nil(file.Library)
+		req.Len(file.Diagnostics, 2, library)
+		req.Equal("asdf_library: "+message, file.Diagnostics[0].String())
+		req.Equal("history.software: "+message, file.Diagnostics[1].String())
+		value := file.Tree.Path("asdf_library").Data().(schema.TaggedValue)
+		req.Equal("tag:stsci.edu:asdf/"+problem.tag, value.Node.Tag)
+		entry := file.History.Entries[0]
+		req.Empty(entry.Software)
+		req.Contains(entry.Extra, "software")
+		buffer := &bytes.Buffer{}
+		_, err = file.WriteTo(buffer)
+		req.NoError(err, library)
+		req.Contains(buffer.String(), "asdf_library: "+library)
+		req.Contains(buffer.String(), "software: "+library)
+		req.NotContains(buffer.String(), "go-asdf")
+		_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Strict: true})
+		req.EqualError(err, "while parsing core/asdf-1.1.0/asdf_library: asdf_library: "+message)
+	}
+}
+
+func TestOpenStrict(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenWithOptions(bytes.NewReader(makeLenientFile(req)), OpenOptions{Strict: true})
+	req.NoError(err)
+	req.Empty(file.Diagnostics)
+	for _, problem := range lenientProblems {
+		contents := makeLenientFile(req, problem.key)
+		file, err = Open(bytes.NewReader(contents), nil)
+		req.NoError(err)
+		req.NotEmpty(file.Diagnostics, problem.key)
+		_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Strict: true})
+		req.Error(err, problem.key)
+	}
+}
+
+func TestOpenValidate(t *testing.T) {
+	req := require.New(t)
+	files := []string{"testdata/default.asdf", "testdata/inline.asdf"}
+	for _, name := range []string{"basic", "complex", "compressed", "exploded", "float", "int",
+		"shared", "ascii", "unicode_bmp", "unicode_spp"} {
+		files = append(files, "testdata/standard/"+name+".asdf")
+	}
+	for _, name := range files {
+		file, err := OpenFileWithOptions(name, OpenOptions{Validate: true})
+		req.NoError(err, name)
+		buffer := &bytes.Buffer{}
+		_, err = file.WriteTo(buffer)
+		req.NoError(err, name)
+		_, err = OpenWithOptions(bytes.NewReader(buffer.Bytes()), OpenOptions{Validate: true})
+		req.NoError(err, name)
+	}
+	// float16 is not in the standard, but go-asdf writes it
+	tree := gabs.New()
+	_, err := tree.Set(&core.NDArray{
+		DataType: core.Float16, Shape: []int{2}, ByteOrder: binary.LittleEndian,
+		Data: []byte{0x00, 0x3c, 0x00, 0xc0},
+	}, "block")
+	req.NoError(err)
+	_, err = tree.Set(&core.NDArray{
+		DataType: core.Float16, Shape: []int{1}, ByteOrder: binary.LittleEndian,
+		Data: []byte{0x00, 0x3c}, Inline: true,
+	}, "inline")
+	req.NoError(err)
+	buffer := &bytes.Buffer{}
+	_, err = (&File{Document: core.Document{Tree: tree}}).WriteTo(buffer)
+	req.NoError(err)
+	_, err = OpenWithOptions(bytes.NewReader(buffer.Bytes()), OpenOptions{Validate: true})
+	req.NoError(err)
+	// including the structured fields, which are kept unsupported in lenient mode
+	structured := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+arr: !core/ndarray-1.0.0
+  source: 0
+  datatype: [{name: a, datatype: float16}, {name: b, datatype: [int32, float16]}, float16]
+  byteorder: little
+  shape: [2]
+...
+`)
+	_, err = OpenWithOptions(bytes.NewReader(structured), OpenOptions{Validate: true})
+	req.NoError(err)
+	_, err = OpenWithOptions(bytes.NewReader(bytes.Replace(structured, []byte("name: a"),
+		[]byte("name: 1a"), 1)), OpenOptions{Validate: true})
+	req.Error(err)
+	contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+asdf_library: !core/software-1.0.0 {name: test}
+arr: !core/ndarray-1.0.0 {source: 0, datatype: int32, byteorder: middle, shape: [2]}
+...
+`)
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Validate: true})
+	req.Error(err)
+	errs := errors.Cause(err).(schema.ValidationErrors)
+	req.Len(errs, 2)
+	req.Equal("asdf_library", errs[0].Path)
+	req.Equal("http://stsci.edu/schemas/asdf/core/software-1.0.0#/required", errs[0].Rule)
+	req.Equal("arr", errs[1].Path)
+	// the standard schema is extended with the inline scalars
+	req.Equal("http://github.com/src-d/go-asdf/schemas/core/ndarray-1.0.0#/anyOf", errs[1].Rule)
+	// the compatible versions are validated, too
+	for _, tag := range []string{"!core/ndarray-1.1.0"} {
+		modified := bytes.Replace(contents, []byte("!core/ndarray-1.0.0"), []byte(tag), 1)
+		_, err = OpenWithOptions(bytes.NewReader(modified), OpenOptions{Validate: true})
+		req.Error(err, tag)
+		errs = errors.Cause(err).(schema.ValidationErrors)
+		req.Len(errs, 2, tag)
+		req.Equal("arr", errs[1].Path, tag)
+	}
+	scalar := bytes.Replace(contents,
+		[]byte("{source: 0, datatype: int32, byteorder: middle, shape: [2]}"),
+		[]byte("{data: 5, datatype: int32}"), 1)
+	_, err = OpenWithOptions(bytes.NewReader(scalar), OpenOptions{Validate: true})
+	req.Len(errors.Cause(err).(schema.ValidationErrors), 1)
+	contents = bytes.Replace(contents, []byte("arr: "), []byte("arr: {}\n#"), 1)
+	_, err = Open(bytes.NewReader(contents), nil)
+	req.NoError(err)
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{Validate: true})
+	req.Error(err)
+	_, err = OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+		Validate: true, Validator: schema.NewValidator(),
+	})
+	req.NoError(err)
+}
+
+func TestOpenFileCopy(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFile("testdata/standard/shared.asdf", nil)
+	req.NoError(err)
+	// nothing references the mapping
+	req.Nil(file.closer)
+	arr := file.Tree.Path("data").Data().(*core.NDArray)
+	data := append([]byte{}, arr.Data...)
+	req.NoError(file.Close())
+	req.Equal(data, arr.Data)
+}
+
+func TestOpenFileClosedLazy(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/default.asdf")
+	req.NoError(err)
+	// the blocks must be scanned from the mapping
+	contents = contents[:bytes.Index(contents, []byte(blockIndexHeader))]
+	dir, err := ioutil.TempDir("", "asdf")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "noindex.asdf")
+	req.NoError(ioutil.WriteFile(fileName, contents, 0666))
+	for _, mmap := range []bool{false, true} {
+		file, err := OpenFileWithOptions(fileName, OpenOptions{Lazy: true, Mmap: mmap})
+		req.NoError(err)
+		req.NoError(file.Close())
+		arrs := file.Tree.Path("arrs").Data().([]interface{})
+		req.EqualError(arrs[2].(*core.NDArray).Load(),
+			"reading block #3: the file is closed")
+	}
+}
+
+func TestOpenTypedAccessors(t *testing.T) {
+	req := require.New(t)
+	for _, lazy := range []bool{false, true} {
+		file, err := OpenFileWithOptions("testdata/default.asdf", OpenOptions{Lazy: lazy})
+		req.NoError(err)
+		arrs := file.Tree.Path("arrs").Data().([]interface{})
+		floats, err := arrs[0].(*core.NDArray).Float32s()
+		req.NoError(err)
+		req.Len(floats, 500)
+		raw := arrs[0].(*core.NDArray).Data
+		req.Equal(binary.LittleEndian.Uint32(raw[4*7:]), math.Float32bits(floats[7]))
+		// zero-copy
+		req.Equal(uintptr(unsafe.Pointer(&raw[0])), uintptr(unsafe.Pointer(&floats[0])))
+		_, err = arrs[0].(*core.NDArray).Float64s()
+		req.EqualError(err, "array<float32, LittleEndian> of shape [500]: "+
+			"data type mismatch: requested float64")
+		pixels, err := arrs[1].(*core.NDArray).Uint8s()
+		req.NoError(err)
+		req.Len(pixels, 1500)
+		five := file.Tree.Path("one.four.five").Data().(*core.NDArray)
+		ints, err := five.Int32s()
+		req.NoError(err)
+		req.Len(ints, 1000)
+		value, err := five.At(999)
+		req.NoError(err)
+		req.Equal(ints[999], value)
+		req.NoError(file.Close())
+	}
+}
+
+func TestMarshalView(t *testing.T) {
+	req := require.New(t)
+	arr := &core.NDArray{
+		DataType:  types.Typ[types.Float64],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{2, 3, 4},
+		Data:      make([]byte, 2*3*4*8),
+	}
+	for i := 0; i < 24; i++ {
+		req.NoError(arr.Set(float64(i), i/12, i/4%3, i%4))
+	}
+	view, err := arr.Slice(core.Range{Start: 1, Stop: 2}, core.Range{Start: -1, Stop: -4, Step: -2},
+		core.Range{Start: 1, Stop: 100, Step: 2})
+	req.NoError(err)
+	data, err := Marshal(map[string]interface{}{"view": view})
+	req.NoError(err)
+	file, err := Open(bytes.NewReader(data), nil)
+	req.NoError(err)
+	var decoded struct {
+		View [][][]float64 `asdf:"view"`
+	}
+	req.NoError(file.Decode(&decoded))
+	req.Equal([][][]float64{{{21, 23}, {13, 15}}}, decoded.View)
+}
+
+func TestOpenLazyView(t *testing.T) {
+	req := require.New(t)
+	file, err := OpenFileWithOptions("testdata/default.asdf", OpenOptions{Lazy: true})
+	req.NoError(err)
+	arr := file.Tree.Path("arrs").Data().([]interface{})[1].(*core.NDArray)
+	column, err := arr.Slice(core.FullRange, core.Range{Start: 2, Stop: 3})
+	req.NoError(err)
+	req.Equal([]int{500, 1}, column.Shape)
+	pixels, err := column.Uint8s()
+	req.NoError(err)
+	req.Len(pixels, 500)
+	for i, pixel := range pixels {
+		req.Equal(arr.Data[i*3+2], pixel)
+	}
+	req.NoError(file.Close())
+}
@@ -0,0 +1,110 @@
+package asdf
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// mappedFile is the file mapped into memory. The blocks which are read from it alias the mapping
+// instead of being copied, unless the platform does not support it or aliasing is disabled.
+// All the reads fail after Close.
+type mappedFile struct {
+	reader io.ReadSeeker
+	// data is the mapped region, nil if the blocks must be copied.
+	data []byte
+	// aliased indicates whether any block returned by ReadBlock references data.
+	aliased bool
+	closer  func() error
+	// lock protects against reading after Close.
+	lock   sync.Mutex
+	closed bool
+}
+
+// newMappedFile creates the mappedFile over the specified region. The blocks alias the region
+// only if `alias` is true.
+func newMappedFile(data []byte, alias bool, closer func() error) *mappedFile {
+	mf := &mappedFile{reader: bytes.NewReader(data), closer: closer}
+	if alias {
+		mf.data = data
+	}
+	return mf
+}
+
+var errMappedFileClosed = errors.New("the file is closed")
+
+// Read implements io.Reader.
+func (mf *mappedFile) Read(p []byte) (int, error) {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	if mf.closed {
+		return 0, errMappedFileClosed
+	}
+	return mf.reader.Read(p)
+}
+
+// Seek implements io.Seeker.
+func (mf *mappedFile) Seek(offset int64, whence int) (int64, error) {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	if mf.closed {
+		return 0, errMappedFileClosed
+	}
+	return mf.reader.Seek(offset, whence)
+}
+
+// ReadBlock loads the block which starts at the specified offset. The payload of the block is
+// not copied if the region is aliased.
+func (mf *mappedFile) ReadBlock(offset int64, options blockOptions) (*Block, error) {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	if mf.closed {
+		return nil, errMappedFileClosed
+	}
+	if _, err := mf.reader.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if mf.data == nil {
+		return readBlock(mf.reader, options)
+	}
+	block, header, err := readBlockHeader(mf.reader)
+	if err != nil {
+		return nil, err
+	}
+	start, err := mf.reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if block.Flags&FlagStreamed != 0 {
+		block.Data = mf.data[start:]
+		if err = options.checkSize(uint64(len(block.Data))); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = options.checkSize(header.AllocatedSize); err != nil {
+			return nil, err
+		}
+		if header.AllocatedSize > uint64(int64(len(mf.data))-start) {
+			return nil, errors.Wrap(io.ErrUnexpectedEOF, "failed to read the block's payload")
+		}
+		block.Data = mf.data[start : start+int64(header.UsedSize)]
+	}
+	if block.Compression == CompressionNone {
+		mf.aliased = true
+	}
+	return block, nil
+}
+
+// Close unmaps the file. The aliased blocks become inaccessible, and the reads return errors.
+func (mf *mappedFile) Close() error {
+	mf.lock.Lock()
+	defer mf.lock.Unlock()
+	if mf.closed {
+		return errors.New("the file is already closed")
+	}
+	mf.closed = true
+	mf.data = nil
+	return mf.closer()
+}
@@ -0,0 +1,21 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package asdf
+
+import (
+	"io"
+
+	"golang.org/x/exp/mmap"
+)
+
+// mapFile maps the whole file into memory. The blocks are always copied from the mapping.
+func mapFile(fileName string, _ bool) (*mappedFile, error) {
+	reader, err := mmap.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &mappedFile{
+		reader: io.NewSectionReader(reader, 0, int64(reader.Len())),
+		closer: reader.Close,
+	}, nil
+}
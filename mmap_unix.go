@@ -0,0 +1,37 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package asdf
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// mapFile maps the whole file into memory. The mapping is private: the writes to it are not
+// carried to the file. The blocks alias the mapping if `alias` is true.
+func mapFile(fileName string, alias bool) (*mappedFile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return newMappedFile([]byte{}, alias, func() error { return nil }), nil
+	}
+	if int64(int(size)) != size {
+		return nil, errors.Errorf("%s is too big to be mapped: %d bytes", fileName, size)
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	return newMappedFile(data, alias, func() error { return syscall.Munmap(data) }), nil
+}
@@ -0,0 +1,98 @@
+package asdf
+
+// ProgressEventKind is the type of ProgressEvent.
+type ProgressEventKind int
+
+const (
+	// ProgressHeaderParsed is sent after the "#ASDF" header lines are read.
+	ProgressHeaderParsed ProgressEventKind = iota
+	// ProgressTreeParsed is sent after the tree is read and converted. The totals are known
+	// starting from the next event.
+	ProgressTreeParsed
+	// ProgressBlockStarted is sent before the block is read and uncompressed. If
+	// `OpenOptions.Concurrency` is greater than 1, the blocks are read and uncompressed in
+	// the background, and the event is sent when the loading starts to wait for the block,
+	// so the actual work may have begun earlier.
+	ProgressBlockStarted
+	// ProgressBlockFinished is sent after the block is read and uncompressed.
+	ProgressBlockFinished
+)
+
+// ProgressEvent describes a step of reading the file. The byte counters are cumulative, and
+// the totals are taken from the block headers.
+type ProgressEvent struct {
+	// Kind is the type of the event.
+	Kind ProgressEventKind
+	// Block is the index of the block which is being read, -1 if the event does not relate to
+	// a block or the block is external.
+	Block int
+	// URI is the reference to the external file with the block which is being read.
+	URI string
+	// BytesRead is the number of block bytes, as stored in the file, read so far.
+	BytesRead uint64
+	// TotalBytesRead is the final value of `BytesRead`.
+	TotalBytesRead uint64
+	// BytesUncompressed is the number of block bytes uncompressed so far.
+	BytesUncompressed uint64
+	// TotalBytesUncompressed is the final value of `BytesUncompressed`.
+	TotalBytesUncompressed uint64
+}
+
+// ProgressListener receives the structured progress events. They are sent in a deterministic
+// order from one goroutine at a time.
+type ProgressListener func(event ProgressEvent)
+
+// blockSize is the size of a block's payload taken from its header.
+type blockSize struct {
+	// stored is the size of the payload in the file.
+	stored uint64
+	// uncompressed is the size of the uncompressed payload.
+	uncompressed uint64
+}
+
+// progressTracker accumulates the byte counters and sends the events.
+type progressTracker struct {
+	listener ProgressListener
+	event    ProgressEvent
+}
+
+func newProgressTracker(listener ProgressListener) *progressTracker {
+	if listener == nil {
+		listener = func(ProgressEvent) {}
+	}
+	return &progressTracker{listener: listener, event: ProgressEvent{Block: -1}}
+}
+
+// Send reports a step which does not relate to a block.
+func (pt *progressTracker) Send(kind ProgressEventKind) {
+	pt.event.Kind = kind
+	pt.event.Block = -1
+	pt.event.URI = ""
+	pt.listener(pt.event)
+}
+
+// Plan sets the totals.
+func (pt *progressTracker) Plan(sizes []blockSize) {
+	for _, size := range sizes {
+		pt.event.TotalBytesRead += size.stored
+		pt.event.TotalBytesUncompressed += size.uncompressed
+	}
+}
+
+// Start reports that the block is about to be read.
+func (pt *progressTracker) Start(index int, uri string) {
+	pt.event.Kind = ProgressBlockStarted
+	pt.event.Block = index
+	pt.event.URI = uri
+	pt.listener(pt.event)
+}
+
+// Finish reports that the block has been read and uncompressed.
+func (pt *progressTracker) Finish(index int, uri string, size blockSize) {
+	pt.event.Kind = ProgressBlockFinished
+	pt.event.Block = index
+	pt.event.URI = uri
+	pt.event.BytesRead += size.stored
+	pt.event.BytesUncompressed += size.uncompressed
+	pt.listener(pt.event)
+}
@@ -22,14 +22,25 @@ type Document struct {
 	Tree *gabs.Container
 }
 
+// documentVersion is the version of core/asdf which is written.
+var documentVersion = semver.MustParse("1.1.0")
+
+// documentUnmarshaler handles both core/asdf-1.0.0 and core/asdf-1.1.0, they differ only
+// in the supported history formats.
 type documentUnmarshaler struct {
+	version semver.Version
 }
 
 func (du documentUnmarshaler) Version() semver.Version {
-	return semver.MustParse("1.1.0")
+	return du.version
 }
 
 func (du documentUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return du.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (du documentUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	doc := &Document{Tree: gabs.New()}
 	if value.Kind != yaml.MappingNode {
 		return nil, errors.Errorf("tag core/asdf-%s requires a mapping node", du.Version())
@@ -38,42 +49,48 @@ func (du documentUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, erro
 		node := value.Content[i]
 		key := value.Content[i-1].Value
 		if key == "asdf_library" {
-			tag, err := schema.ParseTag(node.Tag)
-			if err != nil {
-				return nil, err
-			}
-			def := schema.FindDefinition(tag)
-			if def == nil {
-				return nil, errors.Errorf("unsupported tag: %s", tag.String())
-			}
-			obj, err := def.UnmarshalYAML(node)
+			restore := decoder.Enter(key)
+			lib, kept, err := decodeSoftware(node, decoder)
+			restore()
 			if err != nil {
 				return nil, errors.Wrapf(err, "while parsing core/asdf-%s/%s", du.Version(), key)
 			}
-			doc.Library = obj.(*Software)
+			if kept != nil {
+				if _, err = doc.Tree.Set(*kept, key); err != nil {
+					return nil, errors.Wrapf(err, "while parsing core/asdf-%s/%s",
+						du.Version(), key)
+				}
+				continue
+			}
+			doc.Library = lib
 		} else if key == "history" {
-			var err error
-			var obj interface{}
+			tag := schema.Tag{Version: semver.MustParse("1.1.0")}
 			switch node.Kind {
 			case yaml.SequenceNode:
-				um := schema.FindDefinition(schema.Tag{
-					Name:    "stsci.edu:asdf/core/history/sequence",
-					Version: semver.MustParse("1.1.0")})
-				obj, err = um.UnmarshalYAML(node)
+				tag.Name = stsciPrefix + "core/history/sequence"
 			case yaml.MappingNode:
-				um := schema.FindDefinition(schema.Tag{
-					Name:    "stsci.edu:asdf/core/history/mapping",
-					Version: semver.MustParse("1.1.0")})
-				obj, err = um.UnmarshalYAML(node)
+				tag.Name = stsciPrefix + "core/history/mapping"
 			default:
-				err = errors.Errorf("invalid history value type: %d", node.Kind)
+				return nil, errors.Errorf("invalid history value type: %d", node.Kind)
+			}
+			um := decoder.FindDefinition(tag)
+			if um == nil {
+				return nil, errors.Errorf("unsupported tag: %s", tag.String())
 			}
+			restore := decoder.Enter(key)
+			obj, err := decoder.Unmarshal(um, node)
+			restore()
 			if err != nil {
 				return nil, err
 			}
-			doc.History = obj.(*History)
+			history, ok := obj.(*History)
+			if !ok {
+				return nil, errors.Errorf("while parsing core/asdf-%s/%s: %s is not handled by "+
+					"core/history", du.Version(), key, tag.String())
+			}
+			doc.History = history
 		} else {
-			err := schema.GabsifyYAML(doc.Tree, node, key)
+			err := decoder.GabsifyYAML(doc.Tree, node, key)
 			if err != nil {
 				return nil, errors.Wrapf(err, "while transforming core/asdf-%s", du.Version())
 			}
@@ -83,6 +100,55 @@ func (du documentUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, erro
 	return doc, nil
 }
 
+// MarshalASDF converts the document to a tagged YAML node. The arrays are appended to `blocks`.
+func (doc Document) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	node := newMappingNode(documentVersion, "core/asdf")
+	if doc.Library != nil {
+		lib, err := doc.Library.MarshalASDF(blocks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while serializing core/asdf-%s/asdf_library",
+				documentVersion)
+		}
+		appendPair(node, "asdf_library", lib)
+	}
+	if doc.History != nil && (len(doc.History.Extensions) > 0 || len(doc.History.Entries) > 0 ||
+		len(doc.History.Extra) > 0) {
+		history, err := doc.History.MarshalASDF(blocks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while serializing core/asdf-%s/history",
+				documentVersion)
+		}
+		appendPair(node, "history", history)
+	}
+	if doc.Tree == nil {
+		return node, nil
+	}
+	tree, err := schema.YAMLifyGabs(doc.Tree, blocks)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while serializing core/asdf-%s",
+			documentVersion)
+	}
+	if tree.Kind != yaml.MappingNode {
+		return nil, errors.Errorf("the tree of core/asdf-%s must be an object",
+			documentVersion)
+	}
+	for i := 0; i < len(tree.Content); i += 2 {
+		key := tree.Content[i].Value
+		if key != "asdf_library" && key != "history" {
+			continue
+		}
+		// the lenient decoder keeps the unsupported values of the reserved keys in the tree
+		_, kept := doc.Tree.Search(key).Data().(schema.TaggedValue)
+		if !kept || (key == "asdf_library" && doc.Library != nil) ||
+			(key == "history" && doc.History != nil) {
+			return nil, errors.Errorf("the tree of core/asdf-%s may not contain the reserved key %s",
+				documentVersion, key)
+		}
+	}
+	node.Content = append(node.Content, tree.Content...)
+	return node, nil
+}
+
 // IterArrays visits all the contained ndarray-s in the document.
 func (doc Document) IterArrays(visitor func(array *NDArray)) {
 	queue := []*gabs.Container{doc.Tree}
@@ -100,5 +166,6 @@ func (doc Document) IterArrays(visitor func(array *NDArray)) {
 }
 
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/asdf"] = []schema.Definition{documentUnmarshaler{}}
+	registerCore("asdf", documentUnmarshaler{version: semver.MustParse("1.0.0")})
+	registerCore("asdf", documentUnmarshaler{version: documentVersion})
 }
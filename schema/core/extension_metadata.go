@@ -14,6 +14,8 @@ type ExtensionMetadata struct {
 	Class string
 	// Package indicates the name and the version of the extension.
 	Package schema.Tag
+	// Extra contains the unknown properties which were kept in lenient mode.
+	Extra map[string]*yaml.Node
 }
 
 type extensionMetadataUnmarshaler struct {
@@ -24,6 +26,11 @@ func (emum extensionMetadataUnmarshaler) Version() semver.Version {
 }
 
 func (emum extensionMetadataUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return emum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (emum extensionMetadataUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	if value.Kind != yaml.MappingNode {
 		return nil, errors.Errorf("node type must be a mpping for core/extension_metadata-%s",
 			emum.Version())
@@ -50,13 +57,28 @@ func (emum extensionMetadataUnmarshaler) UnmarshalYAML(value *yaml.Node) (interf
 				}
 			}
 		} else {
-			return nil, errors.Errorf("invalid key in a core/extension_metadata-%s element: %s",
-				emum.Version(), key)
+			err := keepExtra(decoder, &em.Extra, "core/extension_metadata-"+emum.Version().String(),
+				key, node)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return em, nil
 }
 
+// MarshalASDF converts the object to a tagged YAML node.
+func (em ExtensionMetadata) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	node := newMappingNode(extensionMetadataUnmarshaler{}.Version(), "core/extension_metadata")
+	appendStringPair(node, "extension_class", em.Class)
+	software := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+	appendStringPair(software, "name", em.Package.Name)
+	appendStringPair(software, "version", em.Package.Version.String())
+	appendPair(node, "software", software)
+	appendExtra(node, em.Extra)
+	return node, nil
+}
+
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/extension_metadata"] = []schema.Definition{extensionMetadataUnmarshaler{}}
+	registerCore("extension_metadata", extensionMetadataUnmarshaler{})
 }
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"go/types"
+	"math"
+)
+
+// Float16 is the data type of the IEEE 754 half-precision floats, "float16" in ASDF.
+// go/types does not define it, so Float16 is a distinct copy of uint16 and must be compared
+// by pointer: `arr.DataType == core.Float16`. The elements are stored as uint16 bits, see
+// Float16ToFloat32() and Float32ToFloat16().
+var Float16 = func() *types.Basic {
+	basic := *types.Typ[types.Uint16]
+	return &basic
+}()
+
+// Float16ToFloat32 converts the half-precision float bits to float32 without losing precision.
+func Float16ToFloat32(bits uint16) float32 {
+	sign := uint32(bits&0x8000) << 16
+	exponent := uint32(bits>>10) & 0x1f
+	mantissa := uint32(bits & 0x3ff)
+	switch exponent {
+	case 0x1f:
+		// infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	case 0:
+		// zero or subnormal
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+		return value
+	}
+	return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+}
+
+// Float32ToFloat16 converts float32 to the half-precision float bits, rounding to the nearest
+// even. The values which are too big become infinities.
+func Float32ToFloat16(value float32) uint16 {
+	bits := math.Float32bits(value)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		// NaN, keep it quiet
+		return sign | 0x7e00 | uint16(mantissa>>13)
+	case exponent >= 0x1f:
+		return sign | 0x7c00
+	case exponent <= 0:
+		if exponent < -10 {
+			return sign
+		}
+		// subnormal: restore the implicit leading bit and shift it into the mantissa
+		mantissa |= 0x800000
+		shift := uint(14 - exponent)
+		half := uint16(mantissa >> shift)
+		rest, halfway := mantissa&(1<<shift-1), uint32(1)<<(shift-1)
+		if rest > halfway || (rest == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+	half := uint16(exponent<<10) | uint16(mantissa>>13)
+	rest := mantissa & 0x1fff
+	// the carry may overflow to the exponent, which is correct
+	if rest > 0x1000 || (rest == 0x1000 && half&1 == 1) {
+		half++
+	}
+	return sign | half
+}
@@ -14,6 +14,8 @@ type History struct {
 	Extensions []*ExtensionMetadata
 	// File change history.
 	Entries []*HistoryEntry
+	// Extra contains the unknown properties which were kept in lenient mode.
+	Extra map[string]*yaml.Node
 }
 
 type historySequenceUnmarshaler struct {
@@ -24,6 +26,11 @@ func (hsum historySequenceUnmarshaler) Version() semver.Version {
 }
 
 func (hsum historySequenceUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return hsum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (hsum historySequenceUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	history := &History{}
 	if value.Kind != yaml.SequenceNode {
 		return nil, errors.Errorf("tag core/history-%s requires a sequence node", hsum.Version())
@@ -32,12 +39,12 @@ func (hsum historySequenceUnmarshaler) UnmarshalYAML(value *yaml.Node) (interfac
 	if err != nil {
 		return nil, err
 	}
-	def := schema.FindDefinition(tag)
+	def := decoder.FindDefinition(tag)
 	if def == nil {
 		return nil, errors.Errorf("unsupported tag: %s", tag.String())
 	}
 	for i, node := range value.Content {
-		obj, err := def.UnmarshalYAML(node)
+		obj, err := decoder.Unmarshal(def, node)
 		if err != nil {
 			return nil, errors.Wrapf(err, "while parsing %s/%s[%d]", value.Tag, tag.String(), i)
 		}
@@ -54,6 +61,11 @@ func (hmum historyMappingUnmarshaler) Version() semver.Version {
 }
 
 func (hmum historyMappingUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return hmum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (hmum historyMappingUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	history := &History{}
 	if value.Kind != yaml.MappingNode {
 		return nil, errors.Errorf("tag core/history-%s requires a mapping node", hmum.Version())
@@ -61,8 +73,15 @@ func (hmum historyMappingUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface
 	for i := 1; i < len(value.Content); i += 2 {
 		node := value.Content[i]
 		key := value.Content[i-1].Value
-		if (key != "extensions" && key != "entries") || node.Kind != yaml.SequenceNode ||
-			len(node.Content) == 0 || node.Content[0].Tag == "" {
+		if key != "extensions" && key != "entries" {
+			err := keepExtra(decoder, &history.Extra, "core/history-"+hmum.Version().String(),
+				key, node)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if node.Kind != yaml.SequenceNode || len(node.Content) == 0 || node.Content[0].Tag == "" {
 			return nil, errors.Errorf("invalid key in a core/history-%s element: %s",
 				hmum.Version(), key)
 		}
@@ -74,12 +93,19 @@ func (hmum historyMappingUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface
 		if err != nil {
 			return nil, err
 		}
-		def := schema.FindDefinition(tag)
+		def := decoder.FindDefinition(tag)
 		if def == nil {
-			return nil, errors.Errorf("unsupported tag: %s", tag.String())
+			if err = decoder.Report("unsupported tag: %s", tag.String()); err != nil {
+				return nil, err
+			}
+			if history.Extra == nil {
+				history.Extra = map[string]*yaml.Node{}
+			}
+			history.Extra[key] = node
+			continue
 		}
 		for j, sub := range node.Content {
-			obj, err := def.UnmarshalYAML(sub)
+			obj, err := decoder.Unmarshal(def, sub)
 			if err != nil {
 				return nil, errors.Wrapf(err, "while parsing core/history-%s/%s[%d]",
 					hmum.Version(), key, j)
@@ -94,9 +120,36 @@ func (hmum historyMappingUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface
 	return history, nil
 }
 
+// MarshalASDF converts the object to a YAML node. The mapping form is always used.
+func (h History) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	if len(h.Extensions) > 0 {
+		extensions := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, ext := range h.Extensions {
+			child, err := ext.MarshalASDF(blocks)
+			if err != nil {
+				return nil, err
+			}
+			extensions.Content = append(extensions.Content, child)
+		}
+		appendPair(node, "extensions", extensions)
+	}
+	if len(h.Entries) > 0 {
+		entries := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, entry := range h.Entries {
+			child, err := entry.MarshalASDF(blocks)
+			if err != nil {
+				return nil, err
+			}
+			entries.Content = append(entries.Content, child)
+		}
+		appendPair(node, "entries", entries)
+	}
+	appendExtra(node, h.Extra)
+	return node, nil
+}
+
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/history/sequence"] =
-		[]schema.Definition{historySequenceUnmarshaler{}}
-	schema.Definitions["stsci.edu:asdf/core/history/mapping"] =
-		[]schema.Definition{historyMappingUnmarshaler{}}
+	schema.DefaultRegistry.MustRegister(stsciPrefix+"core/history/sequence", historySequenceUnmarshaler{})
+	schema.DefaultRegistry.MustRegister(stsciPrefix+"core/history/mapping", historyMappingUnmarshaler{})
 }
@@ -17,6 +17,8 @@ type HistoryEntry struct {
 	// Software is the list of https://asdf-standard.readthedocs.io/en/latest/generated/stsci.edu/asdf/core/software-1.0.0.html
 	// It may contain one or more elements.
 	Software []*Software
+	// Extra contains the unknown properties which were kept in lenient mode.
+	Extra map[string]*yaml.Node
 }
 
 type historyEntryUnmarshaler struct {
@@ -27,6 +29,11 @@ func (heum historyEntryUnmarshaler) Version() semver.Version {
 }
 
 func (heum historyEntryUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return heum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (heum historyEntryUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	if value.Kind != yaml.MappingNode {
 		return nil, errors.Errorf("node type must be a mpping for core/history_entry-%s",
 			heum.Version())
@@ -44,30 +51,59 @@ func (heum historyEntryUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}
 			if node.Kind != yaml.SequenceNode {
 				children = []*yaml.Node{node}
 			}
+			restore := decoder.Enter(key)
 			for _, child := range children {
-				tag, err := schema.ParseTag(child.Tag)
-				if err != nil {
-					return nil, err
-				}
-				def := schema.FindDefinition(tag)
-				if def == nil {
-					return nil, errors.Errorf("unsupported tag: %s", tag.String())
-				}
-				sw, err := def.UnmarshalYAML(child)
+				sw, kept, err := decodeSoftware(child, decoder)
 				if err != nil {
+					restore()
 					return nil, errors.Wrapf(err, "while parsing core/history_entry-%s/software",
 						heum.Version())
 				}
-				he.Software = append(he.Software, sw.(*Software))
+				if kept != nil {
+					// the whole list is kept as is
+					if he.Extra == nil {
+						he.Extra = map[string]*yaml.Node{}
+					}
+					he.Extra[key] = node
+					he.Software = nil
+					break
+				}
+				he.Software = append(he.Software, sw)
 			}
+			restore()
 		} else {
-			return nil, errors.Errorf("invalid key in a core/history_entry-%s: %s",
-				heum.Version(), key)
+			err := keepExtra(decoder, &he.Extra, "core/history_entry-"+heum.Version().String(),
+				key, node)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return he, nil
 }
 
+// MarshalASDF converts the object to a tagged YAML node.
+func (he HistoryEntry) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	node := newMappingNode(historyEntryUnmarshaler{}.Version(), "core/history_entry")
+	appendStringPair(node, "description", he.Description)
+	if he.Time != "" {
+		appendStringPair(node, "time", he.Time)
+	}
+	if len(he.Software) > 0 {
+		software := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, sw := range he.Software {
+			child, err := sw.MarshalASDF(blocks)
+			if err != nil {
+				return nil, err
+			}
+			software.Content = append(software.Content, child)
+		}
+		appendPair(node, "software", software)
+	}
+	appendExtra(node, he.Extra)
+	return node, nil
+}
+
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/history_entry"] = []schema.Definition{historyEntryUnmarshaler{}}
+	registerCore("history_entry", historyEntryUnmarshaler{})
 }
@@ -4,13 +4,14 @@ import (
 	"encoding/binary"
 	"fmt"
 	"go/types"
-	"log"
 	"math"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
-	"github.com/Jeffail/gabs/v2"
 	"github.com/blang/semver"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -23,38 +24,261 @@ import (
 type NDArray struct {
 	// DataType is the tensor element type.
 	DataType *types.Basic
-	// Shape is the tensor shape: a one-dimensional integer sequence.
+	// StringLength is the maximum number of characters in each element if DataType is ASCII
+	// or UCS4.
+	StringLength int
+	// Shape is the tensor shape: a one-dimensional integer sequence. The first dimension of
+	// a streamed array is negative until the data is loaded.
 	Shape []int
 	// ByteOrder is the byte order if the tensor contains integers.
 	ByteOrder binary.ByteOrder
-	// Data is the raw tensor buffer, similar to `numpy.ndarray.data`.
+	// Data is the raw tensor buffer, similar to `numpy.ndarray.data`. It is nil until `Load()`
+	// is called if the file was opened lazily. The views created by Slice(), Transpose(),
+	// Reshape() and Squeeze() share `Data` with the original tensor, see Strides() and Offset().
 	Data []byte
+	// Extra contains the unknown properties which were kept in lenient mode. The unsupported
+	// "datatype" is kept here, too, while `DataType` and `Data` remain nil; such arrays cannot
+	// be written.
+	Extra map[string]*yaml.Node
+	// Inline makes MarshalASDF write the elements to the tree instead of a binary block.
+	// It is set for the arrays which were read from the inline data.
+	Inline bool
+
+	// source is the index of the binary block which contains the data plus one, 0 if the data
+	// is inline or external, so that the zero value references no block.
+	source int
+	// sourceURI is the reference to the external file which contains the data in its first block.
+	sourceURI string
+	// position is the location of the elements in the referenced binary block.
+	position ndarrayPosition
+	// loader fetches the data on demand, nil if the data has already been assigned.
+	loader *ndarrayLoader
+	// view is the location of the elements in `Data`, nil means C-contiguous without the offset.
+	view *ndarrayPosition
+}
+
+// BlockLoader returns the uncompressed contents of the binary block referenced by an NDArray.
+type BlockLoader func() ([]byte, error)
+
+type ndarrayLoader struct {
+	lock  sync.Mutex
+	load  BlockLoader
+	done  bool
+	data  []byte
+	shape []int
+	err   error
 }
 
 type ndarrayPosition struct {
 	// Strides is the numbers of bytes to step in each dimension when traversing the tensor.
+	// Strides may be negative, and the elements may overlap. nil means C-contiguous.
 	Strides []int
 	// Offset is the number of bytes to initially skip in the block.
 	Offset int
 }
 
+// contiguous returns the strides of the C-contiguous tensor.
+func contiguous(shape []int, elemSize int) []int {
+	strides := make([]int, len(shape))
+	stride := elemSize
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= shape[i]
+	}
+	return strides
+}
+
+// IsContiguous returns true if the tensor elements occupy a single C-ordered range of the block.
+func (pos ndarrayPosition) IsContiguous(shape []int, elemSize int) bool {
+	if pos.Strides == nil {
+		return true
+	}
+	for i, stride := range contiguous(shape, elemSize) {
+		// the stride of a dimension of size 1 never matters
+		if shape[i] > 1 && pos.Strides[i] != stride {
+			return false
+		}
+	}
+	return true
+}
+
+// Gather copies the tensor elements from the block to a new C-contiguous buffer.
+func (pos ndarrayPosition) Gather(block []byte, shape []int, elemSize int) ([]byte, error) {
+	if len(pos.Strides) != len(shape) {
+		return nil, errors.Errorf("strides %v do not match the shape %v", pos.Strides, shape)
+	}
+	size := elemSize
+	low, high := pos.Offset, pos.Offset
+	for i, dim := range shape {
+		size *= dim
+		if dim == 0 {
+			return []byte{}, nil
+		}
+		if extent := (dim - 1) * pos.Strides[i]; extent < 0 {
+			low += extent
+		} else {
+			high += extent
+		}
+	}
+	if low < 0 || high+elemSize > len(block) {
+		return nil, errors.Errorf("the view [%d, %d) is out of the block bounds [0, %d)",
+			low, high+elemSize, len(block))
+	}
+	data := make([]byte, 0, size)
+	index := make([]int, len(shape))
+	last := len(shape) - 1
+	offset := pos.Offset
+	for len(data) < size {
+		if last < 0 {
+			// scalar
+			data = append(data, block[offset:offset+elemSize]...)
+			break
+		}
+		if pos.Strides[last] == elemSize {
+			data = append(data, block[offset:offset+elemSize*shape[last]]...)
+		} else {
+			for i, rowOffset := 0, offset; i < shape[last]; i, rowOffset = i+1, rowOffset+pos.Strides[last] {
+				data = append(data, block[rowOffset:rowOffset+elemSize]...)
+			}
+		}
+		// advance the multi-dimensional index, skipping the last dimension
+		for dim := last - 1; dim >= 0; dim-- {
+			index[dim]++
+			offset += pos.Strides[dim]
+			if index[dim] < shape[dim] {
+				break
+			}
+			offset -= index[dim] * pos.Strides[dim]
+			index[dim] = 0
+		}
+	}
+	return data, nil
+}
+
+// Source returns the index of the binary block which contains the data. The returned value is
+// -1 if the data is inline or external.
+func (arr NDArray) Source() int {
+	return arr.source - 1
+}
+
+// SourceURI returns the URI of the external ASDF file which contains the data in its first block.
+// The URI is usually relative to the referencing file. It is empty if the data is not external.
+func (arr NDArray) SourceURI() string {
+	return arr.sourceURI
+}
+
+// SetBlockLoader postpones assigning `Data` until `Load()` is called. The loader is invoked
+// at most once.
+func (arr *NDArray) SetBlockLoader(loader BlockLoader) {
+	arr.loader = &ndarrayLoader{load: loader}
+}
+
+// AttachBlock assigns `Data` from the uncompressed contents of the referenced binary block.
+// The first dimension of a streamed array is calculated from the block size, the trailing
+// incomplete row is ignored. Views which are not contiguous are copied, so `Data` is always
+// C-contiguous.
+func (arr *NDArray) AttachBlock(block []byte) error {
+	offset := arr.position.Offset
+	if offset > len(block) {
+		return errors.Errorf("%s: the offset is out of the block bounds: %d > %d",
+			arr.String(), offset, len(block))
+	}
+	if !arr.position.IsContiguous(arr.Shape, arr.ElementSize()) {
+		data, err := arr.position.Gather(block, arr.Shape, arr.ElementSize())
+		if err != nil {
+			return errors.Wrap(err, arr.String())
+		}
+		arr.Data = data
+		return nil
+	}
+	block = block[offset:]
+	if len(arr.Shape) > 0 && arr.Shape[0] < 0 {
+		rowSize := arr.ElementSize()
+		for _, dim := range arr.Shape[1:] {
+			rowSize *= dim
+		}
+		shape := make([]int, len(arr.Shape))
+		copy(shape, arr.Shape)
+		if rowSize > 0 {
+			shape[0] = len(block) / rowSize
+		} else {
+			shape[0] = 0
+		}
+		arr.Shape = shape
+	}
+	size := arr.CountBytes()
+	if len(block) < size {
+		return errors.Errorf("%s: the block is too small: %d < %d", arr.String(), len(block), size)
+	}
+	arr.Data = block[:size]
+	return nil
+}
+
+// Load fetches the data if the file was opened lazily. It does nothing otherwise.
+// Load is safe to call from several goroutines at once.
+func (arr *NDArray) Load() error {
+	loader := arr.loader
+	if loader == nil {
+		return nil
+	}
+	loader.lock.Lock()
+	defer loader.lock.Unlock()
+	if !loader.done {
+		loader.done = true
+		block, err := loader.load()
+		if err == nil {
+			err = arr.AttachBlock(block)
+		}
+		loader.data, loader.shape, loader.err = arr.Data, arr.Shape, err
+	}
+	if loader.err != nil {
+		return loader.err
+	}
+	arr.Data, arr.Shape = loader.data, loader.shape
+	return nil
+}
+
 // String formats the tensor as a string. The actual contents are not included.
 func (arr NDArray) String() string {
 	dims := make([]string, 0, len(arr.Shape))
 	for _, s := range arr.Shape {
-		dims = append(dims, strconv.Itoa(s))
+		if s < 0 {
+			dims = append(dims, "*")
+		} else {
+			dims = append(dims, strconv.Itoa(s))
+		}
 	}
-	return fmt.Sprintf("array<%s, %s> of shape [%s]", arr.DataType.String(),
+	var dtype string
+	if raw, exists := arr.Extra["datatype"]; exists && arr.DataType == nil {
+		dtype = raw.Value
+	} else if arr.DataType == nil {
+		dtype = "unknown"
+	} else {
+		dtype = arr.DataType.String()
+	}
+	if arr.DataType == Float16 {
+		dtype = "float16"
+	} else if isStringType(arr.DataType) {
+		dtype = fmt.Sprintf("[%s, %d]", stringDataTypeName(arr.DataType), arr.StringLength)
+	}
+	return fmt.Sprintf("array<%s, %s> of shape [%s]", dtype,
 		arr.ByteOrder.String(), strings.Join(dims, ", "))
 }
 
-// ReflectedDataType returns the data type as a reflect.Type.
+// ReflectedDataType returns the data type as a reflect.Type. It is uint16 for Float16 and string
+// for ASCII and UCS4, although the elements of the latter are stored as fixed-width bytes.
 func (arr NDArray) ReflectedDataType() reflect.Type {
 	return reflectMapping[arr.DataType.Name()]
 }
 
 // ElementSize returns the data type size in bytes.
 func (arr NDArray) ElementSize() int {
+	switch arr.DataType {
+	case ASCII:
+		return arr.StringLength
+	case UCS4:
+		return arr.StringLength * 4
+	}
 	return int((&types.StdSizes{WordSize: 8, MaxAlign: 8}).Sizeof(arr.DataType.Underlying()))
 }
 
@@ -77,27 +301,48 @@ func (arr *NDArray) EnsureHostEndianness() {
 	if arr.ByteOrder.String() == hbo.String() {
 		return
 	}
+	// the size of the scalars which are swapped
 	dts := arr.ElementSize()
+	switch {
+	case arr.DataType == ASCII:
+		dts = 1
+	case arr.DataType == UCS4:
+		dts = 4
+	case (arr.DataType.Info() & types.IsComplex) != 0:
+		dts /= 2
+	}
 	if dts == 1 {
+		arr.ByteOrder = hbo
 		return
 	}
 	// we cannot run in-place because several arrays can reference the same byte slice
 	fixed := make([]byte, len(arr.Data))
-	for offset := 0; offset < len(arr.Data); offset += dts {
+	swapBytes(fixed, arr.Data, arr.ByteOrder, dts)
+	arr.Data = fixed
+	arr.ByteOrder = hbo
+}
+
+// swapBytes copies the scalars of size `dts` from `src` in the byte order `order` to `dst`
+// in the host byte order.
+func swapBytes(dst, src []byte, order binary.ByteOrder, dts int) {
+	if order.String() == hbo.String() || dts == 1 {
+		copy(dst, src)
+		return
+	}
+	for offset := 0; offset+dts <= len(src); offset += dts {
 		switch dts {
 		case 2:
-			hbo.PutUint16(fixed[offset:offset+2], arr.ByteOrder.Uint16(arr.Data[offset:offset+2]))
+			hbo.PutUint16(dst[offset:offset+2], order.Uint16(src[offset:offset+2]))
 		case 4:
-			hbo.PutUint32(fixed[offset:offset+4], arr.ByteOrder.Uint32(arr.Data[offset:offset+4]))
+			hbo.PutUint32(dst[offset:offset+4], order.Uint32(src[offset:offset+4]))
 		case 8:
-			hbo.PutUint64(fixed[offset:offset+8], arr.ByteOrder.Uint64(arr.Data[offset:offset+8]))
+			hbo.PutUint64(dst[offset:offset+8], order.Uint64(src[offset:offset+8]))
 		}
 	}
-	arr.Data = fixed
-	arr.ByteOrder = hbo
 }
 
 var basicMapping = map[string]*types.Basic{
+	"bool8":      types.Typ[types.Bool],
 	"int8":       types.Typ[types.Int8],
 	"int16":      types.Typ[types.Int16],
 	"int32":      types.Typ[types.Int32],
@@ -106,6 +351,7 @@ var basicMapping = map[string]*types.Basic{
 	"uint16":     types.Typ[types.Uint16],
 	"uint32":     types.Typ[types.Uint32],
 	"uint64":     types.Typ[types.Uint64],
+	"float16":    Float16,
 	"float32":    types.Typ[types.Float32],
 	"float64":    types.Typ[types.Float64],
 	"complex64":  types.Typ[types.Complex64],
@@ -113,6 +359,7 @@ var basicMapping = map[string]*types.Basic{
 }
 
 var reflectMapping = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
 	"int8":       reflect.TypeOf(int8(0)),
 	"int16":      reflect.TypeOf(int16(0)),
 	"int32":      reflect.TypeOf(int32(0)),
@@ -125,6 +372,7 @@ var reflectMapping = map[string]reflect.Type{
 	"float64":    reflect.TypeOf(float64(0)),
 	"complex64":  reflect.TypeOf(complex64(0)),
 	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
 }
 
 type ndarrayUnmarshaler struct {
@@ -135,24 +383,27 @@ func (ndaum ndarrayUnmarshaler) Version() semver.Version {
 }
 
 func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return ndaum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (ndaum ndarrayUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	pos := ndarrayPosition{}
-	var inlineData *gabs.Container
-	arr := &NDArray{Data: make([]byte, 4), ByteOrder: hbo}
+	var inlineData interface{}
+	arr := &NDArray{ByteOrder: hbo}
 
-	gabsifyInlineData := func(node *yaml.Node) error {
-		root := gabs.New()
-		node.Tag = ""
-		err := schema.GabsifyYAML(root, node, "data")
+	parseInline := func(node *yaml.Node) error {
+		data, err := parseInlineData(node)
 		if err != nil {
 			return errors.Wrapf(err, "while parsing core/ndarray-%s/source: failed "+
 				"to process the inline data", ndaum.Version())
 		}
-		inlineData = root.Path("data")
+		inlineData = data
 		return nil
 	}
 
 	if value.Kind == yaml.SequenceNode {
-		err := gabsifyInlineData(value)
+		err := parseInline(value)
 		if err != nil {
 			return nil, err
 		}
@@ -164,10 +415,21 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 			key := value.Content[i-1].Value
 			if key == "datatype" {
 				var exists bool
+				arr.DataType, arr.StringLength, exists = parseStringDataType(node)
+				if exists {
+					continue
+				}
 				arr.DataType, exists = basicMapping[node.Value]
 				if !exists {
-					log.Printf("unsupported dtype %s - falling back to uint8", node.Value)
-					arr.DataType = basicMapping["uint8"]
+					err := decoder.Report("unsupported dtype of core/ndarray-%s: %s - the data "+
+						"is not loaded", ndaum.Version(), node.Value)
+					if err != nil {
+						return nil, err
+					}
+					if arr.Extra == nil {
+						arr.Extra = map[string]*yaml.Node{}
+					}
+					arr.Extra[key] = node
 				}
 				continue
 			}
@@ -188,10 +450,15 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 						ndaum.Version())
 				}
 				for j, sn := range node.Content {
+					if j == 0 && sn.Value == "*" {
+						// streamed
+						arr.Shape = append(arr.Shape, -1)
+						continue
+					}
 					dim, err := strconv.Atoi(sn.Value)
-					if err != nil {
+					if err != nil || dim < 0 {
 						return nil, errors.Errorf("while parsing core/ndarray-%s: shape[%d] must be "+
-							"an integer, got %s", ndaum.Version(), j, sn.Value)
+							"a non-negative integer, got %s", ndaum.Version(), j, sn.Value)
 					}
 					arr.Shape = append(arr.Shape, dim)
 				}
@@ -200,10 +467,18 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 			if key == "source" {
 				src, err := strconv.Atoi(node.Value)
 				if err != nil {
-					return nil, errors.Errorf("while parsing core/ndarray-%s/source: external blocks "+
-						"are not supported: %s", ndaum.Version(), node.Value)
+					if node.Kind != yaml.ScalarNode || node.Value == "" {
+						return nil, errors.Errorf("while parsing core/ndarray-%s/source: must be "+
+							"an integer or a URI", ndaum.Version())
+					}
+					arr.sourceURI = node.Value
+					continue
+				}
+				if src < 0 {
+					return nil, errors.Errorf("while parsing core/ndarray-%s/source: block index "+
+						"may not be negative (%d)", ndaum.Version(), src)
 				}
-				binary.LittleEndian.PutUint32(arr.Data, uint32(src))
+				arr.source = src + 1
 				continue
 			}
 			if key == "strides" {
@@ -217,9 +492,9 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 						return nil, errors.Errorf("while parsing core/ndarray-%s: strides[%d] must be "+
 							"an integer, got %s", ndaum.Version(), j, sn.Value)
 					}
-					if stride < 1 {
-						return nil, errors.Errorf("while parsing core/ndarray-%s: strides[%d] must be "+
-							"greater than 0, got %s", ndaum.Version(), j, sn.Value)
+					if stride == 0 {
+						return nil, errors.Errorf("while parsing core/ndarray-%s: strides[%d] may not "+
+							"be zero", ndaum.Version(), j)
 					}
 					pos.Strides = append(pos.Strides, stride)
 				}
@@ -239,24 +514,37 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 				continue
 			}
 			if key == "data" {
-				err := gabsifyInlineData(node)
+				err := parseInline(node)
 				if err != nil {
 					return nil, err
 				}
 				continue
 			}
-			return nil, errors.Errorf("unknown property of core/ndarray-%s: %s",
-				ndaum.Version(), key)
-		}
-		if pos.Strides != nil || pos.Offset != 0 {
-			buffer := make([]byte, 4+4*len(pos.Strides))
-			binary.LittleEndian.PutUint32(buffer, uint32(pos.Offset))
-			for i, stride := range pos.Strides {
-				binary.LittleEndian.PutUint32(buffer[4+i*4:], uint32(stride))
+			err := keepExtra(decoder, &arr.Extra, "core/ndarray-"+ndaum.Version().String(), key, node)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if pos.Strides != nil {
+			if len(pos.Strides) != len(arr.Shape) {
+				return nil, errors.Errorf("while parsing core/ndarray-%s: strides %v do not match "+
+					"the shape %v", ndaum.Version(), pos.Strides, arr.Shape)
+			}
+			if len(arr.Shape) > 0 && arr.Shape[0] < 0 && arr.DataType != nil &&
+				!pos.IsContiguous(arr.Shape, arr.ElementSize()) {
+				return nil, errors.Errorf("while parsing core/ndarray-%s: streamed arrays must "+
+					"be contiguous, got strides %v", ndaum.Version(), pos.Strides)
 			}
 		}
+		arr.position = pos
+	}
+	if _, unsupported := arr.Extra["datatype"]; unsupported {
+		// the elements cannot be interpreted
+		arr.Inline = inlineData != nil
+		return arr, nil
 	}
 	if inlineData != nil {
+		arr.Inline = true
 		err := applyInlineData(arr, inlineData)
 		if err != nil {
 			return nil, errors.Wrapf(err, "while parsing core/ndarray-%s/source: failed "+
@@ -266,116 +554,382 @@ func (ndaum ndarrayUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, er
 	return arr, nil
 }
 
-func applyInlineData(arr *NDArray, data *gabs.Container) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.Errorf("%v", r)
+// parseInlineData converts the inline data to nested []interface{} with the scalars
+// as strings.
+func parseInlineData(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.AliasNode:
+		return parseInlineData(node.Alias)
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			elem, err := parseInlineData(child)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
 		}
-	}()
-	if len(data.Children()) == 0 {
+		return list, nil
+	}
+	return nil, errors.Errorf("line %d: only the sequences and the scalars are allowed", node.Line)
+}
+
+func applyInlineData(arr *NDArray, data interface{}) error {
+	if _, isList := data.([]interface{}); !isList {
 		if arr.Shape != nil && (len(arr.Shape) != 1 || arr.Shape[0] != 1) {
 			return errors.Errorf("overridden shape is incompatible with the inline data: %v", arr.Shape)
 		}
 		arr.Shape = []int{1}
 		if arr.DataType == nil {
-			arr.DataType = inferDataType(data.Data())
+			arr.DataType, arr.StringLength = inferDataType(data)
 		}
 		arr.Data = make([]byte, arr.ElementSize())
-		elementToBytes(data.Data(), arr.DataType, arr.Data)
-		return nil
+		return elementToBytes(data.(string), arr.DataType, arr.Data)
 	}
-	elem := data
-	for len(elem.Children()) > 0 {
-		arr.Shape = append(arr.Shape, len(elem.Children()))
-		elem, err = elem.ArrayElement(0)
-		if err != nil {
-			panic(err)
+	shape := []int{}
+	for elem := data; ; elem = elem.([]interface{})[0] {
+		list, isList := elem.([]interface{})
+		if !isList {
+			break
 		}
+		shape = append(shape, len(list))
+		if len(list) == 0 {
+			break
+		}
+	}
+	if arr.Shape != nil && !reflect.DeepEqual(arr.Shape, shape) {
+		return errors.Errorf("overridden shape is incompatible with the inline data: %v", arr.Shape)
 	}
+	arr.Shape = shape
 	if arr.DataType == nil {
-		isFloat := false
-		seq := []*gabs.Container{data}
-		for len(seq) > 0 && !isFloat {
-			slice := seq[len(seq)-1]
-			seq = seq[:len(seq)-1]
-			for _, subseq := range slice.Children() {
-				if len(subseq.Children()) > 0 {
-					seq = append(seq, subseq)
-				} else if (inferDataType(subseq.Data()).Info() & types.IsFloat) != 0 {
-					isFloat = true
-					break
-				}
-			}
-		}
-		if isFloat {
-			arr.DataType = types.Typ[types.Float64]
-		} else {
-			arr.DataType = types.Typ[types.Int]
-		}
+		arr.DataType, arr.StringLength = inferDataType(data)
 	}
 	arr.Data = make([]byte, arr.CountBytes())
 	offset := 0
 	ds := arr.ElementSize()
-	seq := []*gabs.Container{data}
-	for len(seq) > 0 {
-		slice := seq[len(seq)-1]
-		seq = seq[:len(seq)-1]
-		for i := range slice.Children() {
-			subseq := slice.Children()[len(slice.Children())-i-1]
-			if len(subseq.Children()) > 0 {
-				seq = append(seq, subseq)
-			} else {
-				elementToBytes(subseq.Data(), arr.DataType, arr.Data[offset:offset+ds])
-				offset += ds
-				break
+	// the elements are written in C order
+	var flatten func(elem interface{}, dim int) error
+	flatten = func(elem interface{}, dim int) error {
+		list, isList := elem.([]interface{})
+		if dim == len(shape) {
+			if isList {
+				return errors.New("the inline data is not rectangular")
+			}
+			err := elementToBytes(elem.(string), arr.DataType, arr.Data[offset:offset+ds])
+			if err != nil {
+				return err
+			}
+			offset += ds
+			return nil
+		}
+		if !isList || len(list) != shape[dim] {
+			return errors.New("the inline data is not rectangular")
+		}
+		for _, child := range list {
+			if err := flatten(child, dim+1); err != nil {
+				return err
 			}
 		}
+		return nil
 	}
-	return nil
+	return flatten(data, 0)
 }
 
-func inferDataType(elem interface{}) *types.Basic {
-	switch elem.(type) {
-	case int:
-		return types.Typ[types.Int]
-	case float64:
-		return types.Typ[types.Float64]
-	default:
-		log.Panicf("unexpected array element type: %s: %v", reflect.TypeOf(elem), elem)
+// inferDataType chooses the data type of the inline data without the explicit "datatype":
+// int64 if all the elements are integers, bool8 if all the elements are booleans, float64
+// if all the elements are numbers, UCS4 otherwise. The second returned value is
+// the maximum string length.
+func inferDataType(data interface{}) (*types.Basic, int) {
+	allInts, allBools, allNumbers := true, true, true
+	maxLength := 0
+	var visit func(elem interface{})
+	visit = func(elem interface{}) {
+		if list, isList := elem.([]interface{}); isList {
+			for _, child := range list {
+				visit(child)
+			}
+			return
+		}
+		text := elem.(string)
+		if length := utf8.RuneCountInString(text); length > maxLength {
+			maxLength = length
+		}
+		switch value, _ := parseScalar(text); value.(type) {
+		case int64:
+			allBools = false
+		case bool:
+			allInts, allNumbers = false, false
+		case nil:
+			allInts, allBools, allNumbers = false, false, false
+		default:
+			allInts, allBools = false, false
+		}
+	}
+	visit(data)
+	switch {
+	case allInts:
+		return types.Typ[types.Int64], 0
+	case allBools:
+		return types.Typ[types.Bool], 0
+	case allNumbers:
+		return types.Typ[types.Float64], 0
+	}
+	return UCS4, maxLength
+}
+
+// yamlFloat matches the decimal floats of the YAML core schema. strconv.ParseFloat() also
+// accepts the Go-only syntax such as underscores and hexadecimal mantissas.
+var yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// parseScalar converts the YAML scalar to int64, uint64, bool or float64.
+func parseScalar(text string) (interface{}, error) {
+	if value, ok := parseInteger(text); ok {
+		return value, nil
+	}
+	switch text {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch strings.ToLower(text) {
+	case ".nan":
+		return math.NaN(), nil
+	case ".inf", "+.inf":
+		return math.Inf(1), nil
+	case "-.inf":
+		return math.Inf(-1), nil
+	}
+	if yamlFloat.MatchString(text) {
+		if value, err := strconv.ParseFloat(text, 64); err == nil {
+			return value, nil
+		}
+	}
+	return nil, errors.Errorf("%q is not a number", text)
+}
+
+// parseInteger converts the YAML core schema integer to int64 or to uint64 if it does not fit.
+// The decimal integers may have a sign and leading zeros, the hexadecimal ones start with "0x"
+// and the octal ones with "0o".
+func parseInteger(text string) (interface{}, bool) {
+	base := 10
+	if strings.HasPrefix(text, "0x") {
+		base = 16
+	} else if strings.HasPrefix(text, "0o") {
+		base = 8
+	}
+	if base == 10 {
+		if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return value, true
+		}
+		if value, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return value, true
+		}
+		return nil, false
 	}
+	value, err := strconv.ParseUint(text[2:], base, 64)
+	if err != nil {
+		return nil, false
+	}
+	if value <= math.MaxInt64 {
+		return int64(value), true
+	}
+	return value, true
+}
+
+// elementToBytes parses a single inline element and writes it in host byte order.
+func elementToBytes(text string, dtype *types.Basic, out []byte) error {
+	if isStringType(dtype) {
+		return writeString(out, text, dtype, hbo)
+	}
+	value, err := parseScalar(text)
+	if err != nil {
+		return err
+	}
+	converted, err := convertElement(value, dtype)
+	if err != nil {
+		return err
+	}
+	writeElement(out, converted, hbo)
 	return nil
 }
 
-func elementToBytes(elem interface{}, dtype *types.Basic, out []byte) {
-	inferDataType(elem)
-	switch dtype.Kind() {
-	case types.Int8:
-	case types.Uint8:
-		out[0] = byte(elem.(int))
-	case types.Int16:
-	case types.Uint16:
-		hbo.PutUint16(out, uint16(elem.(int)))
-	case types.Int32:
-	case types.Uint32:
-		hbo.PutUint32(out, uint32(elem.(int)))
-	case types.Int64:
-	case types.Uint64:
-		hbo.PutUint64(out, uint64(elem.(int)))
-	case types.Float32:
-		if floatVal, ok := elem.(float64); ok {
-			hbo.PutUint32(out, math.Float32bits(float32(floatVal)))
-		} else {
-			hbo.PutUint32(out, math.Float32bits(float32(elem.(int))))
+// MarshalASDF converts the tensor to a tagged YAML node. The data is written to a separate block
+// unless the array is `Inline`. Streamed arrays are written to the streamed block. The views
+// are written as C-contiguous tensors. The lazy arrays are loaded first.
+func (arr *NDArray) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	if arr.view != nil {
+		contiguous, err := arr.Contiguous()
+		if err != nil {
+			return nil, err
 		}
-	case types.Float64:
-		if floatVal, ok := elem.(float64); ok {
-			hbo.PutUint64(out, math.Float64bits(floatVal))
-		} else {
-			hbo.PutUint64(out, math.Float64bits(float64(elem.(int))))
+		return contiguous.MarshalASDF(blocks)
+	}
+	if raw, exists := arr.Extra["datatype"]; exists {
+		return nil, errors.Errorf("%s: cannot write the unsupported data type %s",
+			arr.String(), raw.Value)
+	}
+	if err := arr.Load(); err != nil {
+		return nil, err
+	}
+	dtype, err := arr.dataTypeNode()
+	if err != nil {
+		return nil, err
+	}
+	if arr.Inline {
+		return arr.marshalInline(dtype)
+	}
+	streamed := len(arr.Shape) > 0 && arr.Shape[0] < 0
+	var source int
+	if streamed {
+		rowSize := arr.ElementSize()
+		for _, dim := range arr.Shape[1:] {
+			rowSize *= dim
+		}
+		if rowSize == 0 || len(arr.Data)%rowSize != 0 {
+			return nil, errors.Errorf("%s: data size %d is not a multiple of the row size %d",
+				arr.String(), len(arr.Data), rowSize)
+		}
+		source, err = blocks.AppendStreamedBlock(arr.Data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if len(arr.Data) != arr.CountBytes() {
+			return nil, errors.Errorf("%s: data size mismatch: %d != %d", arr.String(),
+				len(arr.Data), arr.CountBytes())
+		}
+		source = blocks.AppendBlock(arr.Data)
+	}
+	node := newMappingNode(ndarrayUnmarshaler{}.Version(), "core/ndarray")
+	appendPair(node, "source", &yaml.Node{
+		Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(source)})
+	appendPair(node, "datatype", dtype)
+	if arr.ByteOrder.String() == binary.BigEndian.String() {
+		appendStringPair(node, "byteorder", "big")
+	} else {
+		appendStringPair(node, "byteorder", "little")
+	}
+	shape := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+	for i, dim := range arr.Shape {
+		if i == 0 && streamed {
+			shape.Content = append(shape.Content, &yaml.Node{
+				Kind: yaml.ScalarNode, Tag: "!!str", Value: "*"})
+			continue
+		}
+		shape.Content = append(shape.Content, &yaml.Node{
+			Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(dim)})
+	}
+	appendPair(node, "shape", shape)
+	appendExtra(node, arr.Extra)
+	return node, nil
+}
+
+// marshalInline converts the tensor to a tagged YAML node with the elements in nested sequences.
+func (arr *NDArray) marshalInline(dtype *yaml.Node) (*yaml.Node, error) {
+	if len(arr.Shape) == 0 || arr.Shape[0] < 0 {
+		return nil, errors.Errorf("%s: only the arrays of known shape can be inline", arr.String())
+	}
+	if (arr.DataType.Info() & types.IsComplex) != 0 {
+		return nil, errors.Errorf("%s: complex arrays cannot be inline", arr.String())
+	}
+	if len(arr.Data) != arr.CountBytes() {
+		return nil, errors.Errorf("%s: data size mismatch: %d != %d", arr.String(),
+			len(arr.Data), arr.CountBytes())
+	}
+	ds := arr.ElementSize()
+	offset := 0
+	var nest func(dims []int) *yaml.Node
+	nest = func(dims []int) *yaml.Node {
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := 0; i < dims[0]; i++ {
+			if len(dims) > 1 {
+				seq.Content = append(seq.Content, nest(dims[1:]))
+				continue
+			}
+			seq.Content = append(seq.Content, bytesToElement(
+				arr.Data[offset:offset+ds], arr.DataType, arr.ByteOrder))
+			offset += ds
 		}
+		return seq
+	}
+	node := newMappingNode(ndarrayUnmarshaler{}.Version(), "core/ndarray")
+	appendPair(node, "data", nest(arr.Shape))
+	appendPair(node, "datatype", dtype)
+	appendExtra(node, arr.Extra)
+	return node, nil
+}
+
+// bytesToElement is the inverse of elementToBytes: it formats a single element as a YAML scalar.
+func bytesToElement(data []byte, dtype *types.Basic, order binary.ByteOrder) *yaml.Node {
+	switch value := readElement(data, dtype, order).(type) {
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(value)}
+	case float32:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float",
+			Value: formatFloat(float64(value), 32)}
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: formatFloat(value, 64)}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(value)}
+	}
+}
+
+// formatFloat writes a floating point number with the shortest representation which is parsed
+// back to the same value of the specified bit size.
+func formatFloat(value float64, bitSize int) string {
+	switch {
+	case math.IsNaN(value):
+		return ".nan"
+	case math.IsInf(value, 1):
+		return ".inf"
+	case math.IsInf(value, -1):
+		return "-.inf"
+	}
+	text := strconv.FormatFloat(value, 'g', -1, bitSize)
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return text
+}
+
+// dataTypeNode returns the ASDF data type as a YAML node.
+func (arr NDArray) dataTypeNode() (*yaml.Node, error) {
+	if isStringType(arr.DataType) {
+		return &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: stringDataTypeName(arr.DataType)},
+			{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(arr.StringLength)},
+		}}, nil
+	}
+	name, err := arr.dataTypeName()
+	if err != nil {
+		return nil, err
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, nil
+}
+
+// dataTypeName returns the ASDF name of the element type.
+func (arr NDArray) dataTypeName() (string, error) {
+	if arr.DataType == nil {
+		return "", errors.New("the data type is not set")
+	}
+	if arr.DataType == Float16 {
+		return "float16", nil
+	}
+	if arr.DataType.Kind() == types.Bool {
+		return "bool8", nil
+	}
+	name := arr.DataType.Name()
+	if _, exists := basicMapping[name]; !exists {
+		return "", errors.Errorf("unsupported data type: %s", name)
 	}
+	return name, nil
 }
 
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/ndarray"] = []schema.Definition{ndarrayUnmarshaler{}}
+	registerCore("ndarray", ndarrayUnmarshaler{})
 }
@@ -0,0 +1,461 @@
+package core
+
+import (
+	"encoding/binary"
+	"go/types"
+	"math"
+	"reflect"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Float64s returns the elements of the float64 tensor in C order. The data is loaded if needed.
+// The returned slice shares the memory with `Data` if the byte order is host, `Data` is
+// properly aligned and the tensor is not a view which must be copied by Contiguous(),
+// otherwise the elements are copied.
+func (arr *NDArray) Float64s() ([]float64, error) {
+	result := []float64{}
+	if err := arr.typedElements(types.Float64, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Float32s is the same as Float64s for float32 tensors.
+func (arr *NDArray) Float32s() ([]float32, error) {
+	result := []float32{}
+	if err := arr.typedElements(types.Float32, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Complex128s is the same as Float64s for complex128 tensors.
+func (arr *NDArray) Complex128s() ([]complex128, error) {
+	result := []complex128{}
+	if err := arr.typedElements(types.Complex128, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Complex64s is the same as Float64s for complex64 tensors.
+func (arr *NDArray) Complex64s() ([]complex64, error) {
+	result := []complex64{}
+	if err := arr.typedElements(types.Complex64, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Int64s is the same as Float64s for int64 tensors.
+func (arr *NDArray) Int64s() ([]int64, error) {
+	result := []int64{}
+	if err := arr.typedElements(types.Int64, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Uint64s is the same as Float64s for uint64 tensors.
+func (arr *NDArray) Uint64s() ([]uint64, error) {
+	result := []uint64{}
+	if err := arr.typedElements(types.Uint64, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Int32s is the same as Float64s for int32 tensors.
+func (arr *NDArray) Int32s() ([]int32, error) {
+	result := []int32{}
+	if err := arr.typedElements(types.Int32, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Uint32s is the same as Float64s for uint32 tensors.
+func (arr *NDArray) Uint32s() ([]uint32, error) {
+	result := []uint32{}
+	if err := arr.typedElements(types.Uint32, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Int16s is the same as Float64s for int16 tensors.
+func (arr *NDArray) Int16s() ([]int16, error) {
+	result := []int16{}
+	if err := arr.typedElements(types.Int16, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Uint16s is the same as Float64s for uint16 tensors.
+func (arr *NDArray) Uint16s() ([]uint16, error) {
+	result := []uint16{}
+	if err := arr.typedElements(types.Uint16, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Int8s is the same as Float64s for int8 tensors. The byte order and the alignment do not matter.
+func (arr *NDArray) Int8s() ([]int8, error) {
+	result := []int8{}
+	if err := arr.typedElements(types.Int8, unsafe.Pointer(&result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Uint8s is the same as Float64s for uint8 tensors. The byte order and the alignment do not
+// matter.
+func (arr *NDArray) Uint8s() ([]uint8, error) {
+	return arr.elements(types.Uint8)
+}
+
+// Float16s returns the elements of the Float16 tensor converted to float32 in C order. The data is
+// loaded if needed. The elements are always copied.
+func (arr *NDArray) Float16s() ([]float32, error) {
+	if arr.DataType != Float16 {
+		if arr.DataType == nil {
+			return nil, errors.New("the data type is not set")
+		}
+		return nil, errors.Errorf("%s: data type mismatch: requested float16", arr.String())
+	}
+	data, err := arr.loadedData()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]float32, len(data)/2)
+	for i := range result {
+		result[i] = Float16ToFloat32(arr.ByteOrder.Uint16(data[i*2:]))
+	}
+	return result, nil
+}
+
+// Bools is the same as Float64s for bool8 tensors. The elements are always copied because
+// the bytes other than 0 and 1 are not valid Go booleans.
+func (arr *NDArray) Bools() ([]bool, error) {
+	data, err := arr.elements(types.Bool)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bool, len(data))
+	for i, value := range data {
+		result[i] = value != 0
+	}
+	return result, nil
+}
+
+// At returns the element at the specified index, e.g. `arr.At(1, 2)` for a two-dimensional
+// tensor. The type of the returned value corresponds to `ReflectedDataType()`, e.g. float32,
+// except for Float16, which is converted to float32. The elements of ASCII and UCS4 tensors
+// are strings without the trailing zeros. The data is loaded if needed.
+func (arr *NDArray) At(index ...int) (interface{}, error) {
+	offset, err := arr.elementOffset(index)
+	if err != nil {
+		return nil, err
+	}
+	return readElement(arr.Data[offset:offset+arr.ElementSize()], arr.DataType, arr.ByteOrder), nil
+}
+
+// Set assigns the element at the specified index, respecting `ByteOrder`. The value must be
+// a Go number which converts to the data type without losing the integer part or the imaginary
+// part: for example, float64 can be assigned to float32 tensors but not to int32 tensors.
+// The strings which fit into `StringLength` can be assigned to ASCII and UCS4 tensors.
+// `Data` is modified in place, so the arrays which share it will see the change, too.
+func (arr *NDArray) Set(value interface{}, index ...int) error {
+	offset, err := arr.elementOffset(index)
+	if err != nil {
+		return err
+	}
+	if isStringType(arr.DataType) {
+		str, ok := value.(string)
+		if !ok {
+			return errors.Errorf("%s: %v: cannot set %T", arr.String(), index, value)
+		}
+		err = writeString(arr.Data[offset:offset+arr.ElementSize()], str, arr.DataType,
+			arr.ByteOrder)
+		return errors.Wrapf(err, "%s: %v", arr.String(), index)
+	}
+	converted, err := convertElement(value, arr.DataType)
+	if err != nil {
+		return errors.Wrapf(err, "%s: %v", arr.String(), index)
+	}
+	writeElement(arr.Data[offset:], converted, arr.ByteOrder)
+	return nil
+}
+
+// elements loads the data and returns the bytes of the tensor elements if the data type is
+// `kind`.
+func (arr *NDArray) elements(kind types.BasicKind) ([]byte, error) {
+	if arr.DataType == nil {
+		return nil, errors.New("the data type is not set")
+	}
+	if arr.DataType == Float16 || arr.DataType.Kind() != kind {
+		return nil, errors.Errorf("%s: data type mismatch: requested %s",
+			arr.String(), types.Typ[kind].Name())
+	}
+	return arr.loadedData()
+}
+
+// typedElements loads the elements of the tensor with the data type `kind` and points the slice
+// at `slicePtr`, which must have the corresponding Go element type, to them. The slice aliases
+// the loaded bytes if canAlias() allows, otherwise the elements are copied to a new slice and
+// converted to the host byte order.
+func (arr *NDArray) typedElements(kind types.BasicKind, slicePtr unsafe.Pointer) error {
+	data, err := arr.elements(kind)
+	if err != nil {
+		return err
+	}
+	elemType := reflectMapping[types.Typ[kind].Name()]
+	elemSize := int(elemType.Size())
+	// the size of the scalars which are swapped, complex numbers consist of two
+	scalarSize := elemSize
+	if kind == types.Complex64 || kind == types.Complex128 {
+		scalarSize /= 2
+	}
+	count := len(data) / elemSize
+	if count == 0 {
+		return nil
+	}
+	if scalarSize == 1 || arr.canAlias(data, scalarSize) {
+		aliasSlice(slicePtr, data, count)
+		return nil
+	}
+	copied := reflect.MakeSlice(reflect.SliceOf(elemType), count, count)
+	var buffer []byte
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&buffer))
+	header.Data = copied.Pointer()
+	header.Len = len(data)
+	header.Cap = len(data)
+	swapBytes(buffer, data, arr.ByteOrder, scalarSize)
+	reflect.NewAt(copied.Type(), slicePtr).Elem().Set(copied)
+	return nil
+}
+
+// loadedData loads the data and returns the C-contiguous bytes of the tensor elements.
+func (arr *NDArray) loadedData() ([]byte, error) {
+	if err := arr.Load(); err != nil {
+		return nil, err
+	}
+	count := arr.CountElements()
+	if count < 0 {
+		return nil, errors.Errorf("%s: the shape is unknown", arr.String())
+	}
+	if arr.view != nil {
+		contiguous, err := arr.Contiguous()
+		if err != nil {
+			return nil, err
+		}
+		return contiguous.Data, nil
+	}
+	size := count * arr.ElementSize()
+	if len(arr.Data) < size {
+		return nil, errors.Errorf("%s: the data is too small: %d < %d",
+			arr.String(), len(arr.Data), size)
+	}
+	return arr.Data[:size], nil
+}
+
+// elementOffset returns the position of the element in `Data`.
+func (arr *NDArray) elementOffset(index []int) (int, error) {
+	if arr.DataType == nil {
+		return 0, errors.New("the data type is not set")
+	}
+	if err := arr.Load(); err != nil {
+		return 0, err
+	}
+	if len(index) != len(arr.Shape) {
+		return 0, errors.Errorf("%s: %d indexes are required, got %d",
+			arr.String(), len(arr.Shape), len(index))
+	}
+	offset := arr.Offset()
+	for i, stride := range arr.Strides() {
+		if index[i] < 0 || index[i] >= arr.Shape[i] {
+			return 0, errors.Errorf("%s: index %v is out of bounds", arr.String(), index)
+		}
+		offset += index[i] * stride
+	}
+	if offset < 0 || offset+arr.ElementSize() > len(arr.Data) {
+		return 0, errors.Errorf("%s: the data is too small: %d < %d",
+			arr.String(), len(arr.Data), offset+arr.ElementSize())
+	}
+	return offset, nil
+}
+
+// canAlias checks whether the elements can be accessed in place.
+func (arr *NDArray) canAlias(data []byte, align int) bool {
+	return len(data) > 0 && arr.ByteOrder.String() == hbo.String() &&
+		uintptr(unsafe.Pointer(&data[0]))%uintptr(align) == 0
+}
+
+// aliasSlice points the slice at `slicePtr` to `data`, setting its length to `count`.
+func aliasSlice(slicePtr unsafe.Pointer, data []byte, count int) {
+	header := (*reflect.SliceHeader)(slicePtr)
+	header.Data = uintptr(unsafe.Pointer(&data[0]))
+	header.Len = count
+	header.Cap = count
+}
+
+// readElement decodes a single element. The inferred int and uint become int64 and uint64,
+// Float16 becomes float32, ASCII and UCS4 become string.
+func readElement(data []byte, dtype *types.Basic, order binary.ByteOrder) interface{} {
+	if dtype == Float16 {
+		return Float16ToFloat32(order.Uint16(data))
+	}
+	if isStringType(dtype) {
+		return readString(data, dtype, order)
+	}
+	switch dtype.Kind() {
+	case types.Bool:
+		return data[0] != 0
+	case types.Int8:
+		return int8(data[0])
+	case types.Uint8:
+		return data[0]
+	case types.Int16:
+		return int16(order.Uint16(data))
+	case types.Uint16:
+		return order.Uint16(data)
+	case types.Int32:
+		return int32(order.Uint32(data))
+	case types.Uint32:
+		return order.Uint32(data)
+	case types.Int64:
+		return int64(order.Uint64(data))
+	case types.Uint64:
+		return order.Uint64(data)
+	case types.Float32:
+		return math.Float32frombits(order.Uint32(data))
+	case types.Float64:
+		return math.Float64frombits(order.Uint64(data))
+	case types.Complex64:
+		return complex(math.Float32frombits(order.Uint32(data)),
+			math.Float32frombits(order.Uint32(data[4:])))
+	case types.Complex128:
+		return complex(math.Float64frombits(order.Uint64(data)),
+			math.Float64frombits(order.Uint64(data[8:])))
+	}
+	return nil
+}
+
+// writeElement is the inverse of readElement. `value` must have the Go type which corresponds
+// to the data type.
+func writeElement(out []byte, value interface{}, order binary.ByteOrder) {
+	switch typed := value.(type) {
+	case bool:
+		out[0] = 0
+		if typed {
+			out[0] = 1
+		}
+	case int8:
+		out[0] = byte(typed)
+	case uint8:
+		out[0] = typed
+	case int16:
+		order.PutUint16(out, uint16(typed))
+	case uint16:
+		order.PutUint16(out, typed)
+	case int32:
+		order.PutUint32(out, uint32(typed))
+	case uint32:
+		order.PutUint32(out, typed)
+	case int64:
+		order.PutUint64(out, uint64(typed))
+	case uint64:
+		order.PutUint64(out, typed)
+	case float32:
+		order.PutUint32(out, math.Float32bits(typed))
+	case float64:
+		order.PutUint64(out, math.Float64bits(typed))
+	case complex64:
+		order.PutUint32(out, math.Float32bits(real(typed)))
+		order.PutUint32(out[4:], math.Float32bits(imag(typed)))
+	case complex128:
+		order.PutUint64(out, math.Float64bits(real(typed)))
+		order.PutUint64(out[8:], math.Float64bits(imag(typed)))
+	}
+}
+
+// convertElement converts the Go number to the type which corresponds to the data type.
+// Float16 values become uint16 bits. The booleans are converted only to booleans.
+func convertElement(value interface{}, dtype *types.Basic) (interface{}, error) {
+	if dtype == Float16 {
+		converted, err := convertElement(value, types.Typ[types.Float32])
+		if err != nil {
+			return nil, errors.Wrap(err, "float16")
+		}
+		return Float32ToFloat16(converted.(float32)), nil
+	}
+	target := reflectMapping[dtype.Name()]
+	if target == nil {
+		return nil, errors.Errorf("unsupported data type %s", dtype.Name())
+	}
+	src := reflect.ValueOf(value)
+	if !src.IsValid() {
+		return nil, errors.New("cannot set nil")
+	}
+	fail := func() error {
+		return errors.Errorf("cannot convert %v (%s) to %s", value, src.Type(), target)
+	}
+	if (src.Kind() == reflect.Bool) != (target.Kind() == reflect.Bool) {
+		return nil, fail()
+	}
+	dst := reflect.New(target).Elem()
+	switch src.Kind() {
+	case reflect.Bool:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number := src.Int()
+		switch target.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(number) {
+				return nil, fail()
+			}
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if number < 0 || dst.OverflowUint(uint64(number)) {
+				return nil, fail()
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number := src.Uint()
+		switch target.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if number > math.MaxInt64 || dst.OverflowInt(int64(number)) {
+				return nil, fail()
+			}
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if dst.OverflowUint(number) {
+				return nil, fail()
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if dtype.Info()&(types.IsFloat|types.IsComplex) == 0 {
+			return nil, fail()
+		}
+	case reflect.Complex64, reflect.Complex128:
+		if dtype.Info()&types.IsComplex == 0 {
+			return nil, fail()
+		}
+	default:
+		return nil, fail()
+	}
+	if target.Kind() == reflect.Complex64 || target.Kind() == reflect.Complex128 {
+		// reflect does not convert real numbers to complex
+		switch src.Kind() {
+		case reflect.Complex64, reflect.Complex128:
+			dst.SetComplex(src.Complex())
+		case reflect.Float32, reflect.Float64:
+			dst.SetComplex(complex(src.Float(), 0))
+		default:
+			dst.SetComplex(complex(src.Convert(reflect.TypeOf(float64(0))).Float(), 0))
+		}
+		return dst.Interface(), nil
+	}
+	return src.Convert(target).Interface(), nil
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"encoding/binary"
+	"go/types"
+	"math"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// alignedBytes allocates the buffer which is aligned for any element type.
+func alignedBytes(size int) []byte {
+	words := make([]uint64, size/8+1)
+	return (*[1 << 30]byte)(unsafe.Pointer(&words[0]))[:size:size]
+}
+
+// foreignOrder is the byte order which is not host.
+func foreignOrder() binary.ByteOrder {
+	if hbo.String() == binary.LittleEndian.String() {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func TestNDArrayCanAlias(t *testing.T) {
+	req := require.New(t)
+	data := alignedBytes(16)
+	arr := &NDArray{ByteOrder: hbo}
+	req.True(arr.canAlias(data, 8))
+	req.True(arr.canAlias(data[4:], 4))
+	req.False(arr.canAlias(data[4:], 8))
+	req.False(arr.canAlias(data[1:], 2))
+	req.False(arr.canAlias(data[:0], 1))
+	arr.ByteOrder = foreignOrder()
+	req.False(arr.canAlias(data, 8))
+}
+
+func TestNDArrayTypedAccessorsAlias(t *testing.T) {
+	req := require.New(t)
+	for kind, get := range map[types.BasicKind]func(arr *NDArray) (unsafe.Pointer, int, error){
+		types.Float64: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Float64s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Float32: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Float32s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Complex128: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Complex128s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Complex64: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Complex64s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Int64: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Int64s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Uint64: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Uint64s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Int32: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Int32s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Uint32: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Uint32s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Int16: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Int16s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Uint16: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Uint16s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Int8: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Int8s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+		types.Uint8: func(arr *NDArray) (unsafe.Pointer, int, error) {
+			result, err := arr.Uint8s()
+			return unsafe.Pointer(&result[0]), len(result), err
+		},
+	} {
+		arr := &NDArray{DataType: types.Typ[kind], ByteOrder: hbo, Shape: []int{3}}
+		arr.Data = alignedBytes(arr.CountBytes())
+		ptr, count, err := get(arr)
+		req.NoError(err, arr.String())
+		req.Equal(3, count, arr.String())
+		req.Equal(unsafe.Pointer(&arr.Data[0]), ptr, arr.String())
+	}
+}
+
+func TestNDArrayTypedAccessorsCopy(t *testing.T) {
+	req := require.New(t)
+	arr := &NDArray{DataType: types.Typ[types.Float64], ByteOrder: hbo, Shape: []int{2}}
+	// misaligned
+	arr.Data = alignedBytes(17)[1:]
+	hbo.PutUint64(arr.Data[8:], math.Float64bits(0.25))
+	doubles, err := arr.Float64s()
+	req.NoError(err)
+	req.Equal([]float64{0, 0.25}, doubles)
+	doubles[0] = 1
+	req.Equal(uint64(0), hbo.Uint64(arr.Data))
+
+	// swapped
+	arr = &NDArray{DataType: types.Typ[types.Uint32], ByteOrder: foreignOrder(), Shape: []int{2}}
+	arr.Data = alignedBytes(8)
+	arr.ByteOrder.PutUint32(arr.Data, 7)
+	arr.ByteOrder.PutUint32(arr.Data[4:], 1<<31)
+	uints, err := arr.Uint32s()
+	req.NoError(err)
+	req.Equal([]uint32{7, 1 << 31}, uints)
+	req.NotEqual(unsafe.Pointer(&arr.Data[0]), unsafe.Pointer(&uints[0]))
+
+	// the byte order and the alignment of int8 do not matter
+	arr = &NDArray{DataType: types.Typ[types.Int8], ByteOrder: foreignOrder(), Shape: []int{2}}
+	arr.Data = alignedBytes(3)[1:]
+	arr.Data[1] = 0xff
+	signed, err := arr.Int8s()
+	req.NoError(err)
+	req.Equal([]int8{0, -1}, signed)
+	req.Equal(unsafe.Pointer(&arr.Data[0]), unsafe.Pointer(&signed[0]))
+}
+
+func TestNDArrayTypedAccessorsEmpty(t *testing.T) {
+	req := require.New(t)
+	arr := &NDArray{DataType: types.Typ[types.Int64], ByteOrder: hbo, Shape: []int{0, 3}}
+	ints, err := arr.Int64s()
+	req.NoError(err)
+	req.NotNil(ints)
+	req.Empty(ints)
+	_, err = arr.Int32s()
+	req.EqualError(err, "array<int64, LittleEndian> of shape [0, 3]: "+
+		"data type mismatch: requested int32")
+	_, err = (&NDArray{}).Float64s()
+	req.EqualError(err, "the data type is not set")
+	arr = &NDArray{DataType: types.Typ[types.Int64], ByteOrder: hbo, Shape: []int{2},
+		Data: make([]byte, 15)}
+	_, err = arr.Int64s()
+	req.EqualError(err, "array<int64, LittleEndian> of shape [2]: the data is too small: 15 < 16")
+}
+
+func TestNDArrayByteOrder(t *testing.T) {
+	req := require.New(t)
+	arr := &NDArray{
+		DataType:  types.Typ[types.Int16],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{2, 3},
+		Data:      []byte{0, 1, 0, 2, 0, 3, 0xff, 0xfc, 0, 5, 1, 0},
+	}
+	ints, err := arr.Int16s()
+	req.NoError(err)
+	req.Equal([]int16{1, 2, 3, -4, 5, 256}, ints)
+	// the swapped elements are copied
+	ints[0] = 0
+	req.Equal([]byte{0, 1}, arr.Data[:2])
+	value, err := arr.At(1, 0)
+	req.NoError(err)
+	req.Equal(int16(-4), value)
+	req.NoError(arr.Set(int64(-2), 1, 2))
+	req.NoError(arr.Set(uint8(7), 0, 0))
+	req.Equal([]byte{0xff, 0xfe}, arr.Data[10:])
+	req.Equal([]byte{0, 7}, arr.Data[:2])
+	req.Error(arr.Set(40000, 0, 0))
+	req.Error(arr.Set(1.5, 0, 0))
+	req.Error(arr.Set("1", 0, 0))
+	_, err = arr.At(2, 0)
+	req.EqualError(err, "array<int16, BigEndian> of shape [2, 3]: index [2 0] is out of bounds")
+	_, err = arr.At(1)
+	req.Error(err)
+	_, err = arr.Float32s()
+	req.Error(err)
+
+	arr = &NDArray{
+		DataType:  types.Typ[types.Complex64],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{1},
+		Data:      make([]byte, 8),
+	}
+	req.NoError(arr.Set(2, 0))
+	req.NoError(arr.Set(float32(1.5), 0))
+	complexes, err := arr.Complex64s()
+	req.NoError(err)
+	req.Equal([]complex64{1.5}, complexes)
+	req.NoError(arr.Set(1-2i, 0))
+	value, err = arr.At(0)
+	req.NoError(err)
+	req.Equal(complex64(1-2i), value)
+
+	// the real and imaginary parts are swapped separately
+	arr = &NDArray{
+		DataType:  types.Typ[types.Complex64],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{2},
+		Data:      make([]byte, 16),
+	}
+	req.NoError(arr.Set(1-2i, 0))
+	req.NoError(arr.Set(3.5+0.25i, 1))
+	arr.EnsureHostEndianness()
+	req.NotEqual(binary.BigEndian, arr.ByteOrder)
+	complexes, err = arr.Complex64s()
+	req.NoError(err)
+	req.Equal([]complex64{1 - 2i, 3.5 + 0.25i}, complexes)
+	arr = &NDArray{
+		DataType:  types.Typ[types.Complex128],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{1},
+		Data:      make([]byte, 16),
+	}
+	binary.BigEndian.PutUint64(arr.Data, math.Float64bits(-1.25))
+	binary.BigEndian.PutUint64(arr.Data[8:], math.Float64bits(1e100))
+	arr.EnsureHostEndianness()
+	value, err = arr.At(0)
+	req.NoError(err)
+	req.Equal(complex(-1.25, 1e100), value)
+
+	arr = &NDArray{
+		DataType:  types.Typ[types.Float64],
+		ByteOrder: binary.LittleEndian,
+		Shape:     []int{2},
+		Data:      make([]byte, 17),
+	}
+	// misaligned
+	arr.Data = arr.Data[1:]
+	req.NoError(arr.Set(0.25, 1))
+	doubles, err := arr.Float64s()
+	req.NoError(err)
+	req.Equal([]float64{0, 0.25}, doubles)
+}
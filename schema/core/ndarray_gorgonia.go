@@ -3,15 +3,29 @@
 package core
 
 import (
-	"errors"
 	"unsafe"
 
+	"github.com/pkg/errors"
 	"gorgonia.org/tensor"
 )
 
 // ToGorgoniaTensor packages the tensor as a gorgonia's Dense tensor.
-// The memory is not copied.
+// The memory is not copied unless the tensor is a view which is not C-contiguous. The lazy
+// tensor is loaded first. The ASCII and UCS4 string tensors are not supported.
 func (arr NDArray) ToGorgoniaTensor() (*tensor.Dense, error) {
+	if isStringType(arr.DataType) {
+		return nil, errors.Errorf("%s: gorgonia does not support the fixed-width strings",
+			arr.String())
+	}
+	if arr.view != nil {
+		contiguous, err := arr.Contiguous()
+		if err != nil {
+			return nil, err
+		}
+		arr = *contiguous
+	} else if err := arr.Load(); err != nil {
+		return nil, err
+	}
 	if len(arr.Data) == 0 {
 		return nil, nil
 	}
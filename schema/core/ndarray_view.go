@@ -0,0 +1,251 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Range selects the elements along a dimension, similar to Python's `start:stop:step`.
+// Negative Start and Stop count from the end of the dimension, and both are clamped to the
+// dimension bounds the same way as in Python. Zero Step is treated as 1. Range{} selects
+// nothing, use FullRange to select everything.
+type Range struct {
+	Start int
+	Stop  int
+	Step  int
+}
+
+// FullRange selects all the elements along a dimension.
+var FullRange = Range{Start: 0, Stop: maxInt, Step: 1}
+
+const maxInt = int(^uint(0) >> 1)
+
+// resolve returns the index of the first selected element, the step and the number of
+// the selected elements in the dimension of size `dim`.
+func (r Range) resolve(dim int) (start, step, count int) {
+	step = r.Step
+	if step == 0 {
+		step = 1
+	}
+	clamp := func(index int) int {
+		if index < 0 {
+			index += dim
+			if index < 0 {
+				if step < 0 {
+					return -1
+				}
+				return 0
+			}
+		} else if index >= dim {
+			if step < 0 {
+				return dim - 1
+			}
+			return dim
+		}
+		return index
+	}
+	start, stop := clamp(r.Start), clamp(r.Stop)
+	if step > 0 && stop > start {
+		count = (stop-start-1)/step + 1
+	} else if step < 0 && start > stop {
+		count = (start-stop-1)/(-step) + 1
+	}
+	return start, step, count
+}
+
+// Strides returns the numbers of bytes to step in each dimension when traversing the elements
+// in `Data`, similar to `numpy.ndarray.strides`. They are C-contiguous unless the tensor is
+// a view created by Slice(), Transpose() or Reshape().
+func (arr NDArray) Strides() []int {
+	if arr.view != nil {
+		strides := make([]int, len(arr.view.Strides))
+		copy(strides, arr.view.Strides)
+		return strides
+	}
+	return contiguous(arr.Shape, arr.ElementSize())
+}
+
+// Offset returns the number of bytes in `Data` which precede the first element of the view.
+// It is zero if the tensor is not a view.
+func (arr NDArray) Offset() int {
+	if arr.view != nil {
+		return arr.view.Offset
+	}
+	return 0
+}
+
+// Slice returns the view of the selected elements which shares `Data` with the tensor. The
+// dimensions without the corresponding ranges are selected in full. The data is loaded if
+// needed.
+func (arr *NDArray) Slice(ranges ...Range) (*NDArray, error) {
+	pos, err := arr.viewPosition()
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) > len(arr.Shape) {
+		return nil, errors.Errorf("%s: too many ranges: %d", arr.String(), len(ranges))
+	}
+	shape := make([]int, len(arr.Shape))
+	copy(shape, arr.Shape)
+	for i, r := range ranges {
+		start, step, count := r.resolve(shape[i])
+		if count > 0 {
+			pos.Offset += start * pos.Strides[i]
+		}
+		pos.Strides[i] *= step
+		shape[i] = count
+	}
+	return arr.newView(shape, pos), nil
+}
+
+// Transpose returns the view with the permuted dimensions which shares `Data` with the tensor,
+// similar to `numpy.transpose`. The dimensions are reversed if `axes` are not specified.
+// The data is loaded if needed.
+func (arr *NDArray) Transpose(axes ...int) (*NDArray, error) {
+	pos, err := arr.viewPosition()
+	if err != nil {
+		return nil, err
+	}
+	ndim := len(arr.Shape)
+	if len(axes) == 0 {
+		axes = make([]int, ndim)
+		for i := range axes {
+			axes[i] = ndim - 1 - i
+		}
+	}
+	if len(axes) != ndim {
+		return nil, errors.Errorf("%s: the axes %v do not match the dimensions", arr.String(), axes)
+	}
+	used := make([]bool, ndim)
+	shape := make([]int, ndim)
+	strides := make([]int, ndim)
+	for i, axis := range axes {
+		if axis < 0 || axis >= ndim || used[axis] {
+			return nil, errors.Errorf("%s: the axes %v are not a permutation", arr.String(), axes)
+		}
+		used[axis] = true
+		shape[i] = arr.Shape[axis]
+		strides[i] = pos.Strides[axis]
+	}
+	pos.Strides = strides
+	return arr.newView(shape, pos), nil
+}
+
+// Reshape returns the view with the new shape, similar to `numpy.reshape`. One of the
+// dimensions may be -1, it is then inferred from the number of elements. The view shares `Data`
+// with the tensor if the elements are C-contiguous, otherwise they are copied. The data is
+// loaded if needed.
+func (arr *NDArray) Reshape(shape ...int) (*NDArray, error) {
+	pos, err := arr.viewPosition()
+	if err != nil {
+		return nil, err
+	}
+	shape = append([]int{}, shape...)
+	inferred := -1
+	count := 1
+	for i, dim := range shape {
+		if dim == -1 && inferred < 0 {
+			inferred = i
+			continue
+		}
+		if dim < 0 {
+			return nil, errors.Errorf("%s: invalid shape %v", arr.String(), shape)
+		}
+		count *= dim
+	}
+	if inferred >= 0 {
+		if count == 0 {
+			return nil, errors.Errorf("%s: cannot infer the dimension in %v", arr.String(), shape)
+		}
+		shape[inferred] = arr.CountElements() / count
+		count *= shape[inferred]
+	}
+	if count != arr.CountElements() {
+		return nil, errors.Errorf("%s: cannot reshape to %v", arr.String(), shape)
+	}
+	base := arr
+	if !pos.IsContiguous(arr.Shape, arr.ElementSize()) {
+		if base, err = arr.Contiguous(); err != nil {
+			return nil, err
+		}
+		pos.Offset = 0
+	}
+	pos.Strides = contiguous(shape, arr.ElementSize())
+	return base.newView(shape, pos), nil
+}
+
+// Squeeze returns the view without the dimensions of size 1 which shares `Data` with
+// the tensor, similar to `numpy.squeeze`. The data is loaded if needed.
+func (arr *NDArray) Squeeze() (*NDArray, error) {
+	pos, err := arr.viewPosition()
+	if err != nil {
+		return nil, err
+	}
+	shape, strides := []int{}, []int{}
+	for i, dim := range arr.Shape {
+		if dim != 1 {
+			shape = append(shape, dim)
+			strides = append(strides, pos.Strides[i])
+		}
+	}
+	pos.Strides = strides
+	return arr.newView(shape, pos), nil
+}
+
+// Contiguous returns the tensor with C-contiguous `Data` and without the offset. The tensor
+// itself is returned if it is not a view. The elements are copied only if the view is not
+// C-contiguous, otherwise `Data` is shared. The data is loaded if needed.
+func (arr *NDArray) Contiguous() (*NDArray, error) {
+	if err := arr.Load(); err != nil {
+		return nil, err
+	}
+	if arr.view == nil {
+		return arr, nil
+	}
+	result := *arr
+	result.view = nil
+	size := arr.CountBytes()
+	switch {
+	case size == 0:
+		result.Data = []byte{}
+	case arr.view.IsContiguous(arr.Shape, arr.ElementSize()):
+		offset := arr.view.Offset
+		if offset < 0 || offset+size > len(arr.Data) {
+			return nil, errors.Errorf("%s: the view [%d, %d) is out of the data bounds [0, %d)",
+				arr.String(), offset, offset+size, len(arr.Data))
+		}
+		result.Data = arr.Data[offset : offset+size]
+	default:
+		data, err := arr.view.Gather(arr.Data, arr.Shape, arr.ElementSize())
+		if err != nil {
+			return nil, errors.Wrap(err, arr.String())
+		}
+		result.Data = data
+	}
+	return &result, nil
+}
+
+// viewPosition loads the data and returns the explicit location of the elements in `Data`.
+func (arr *NDArray) viewPosition() (ndarrayPosition, error) {
+	if arr.DataType == nil {
+		return ndarrayPosition{}, errors.New("the data type is not set")
+	}
+	if err := arr.Load(); err != nil {
+		return ndarrayPosition{}, err
+	}
+	if arr.CountElements() < 0 {
+		return ndarrayPosition{}, errors.Errorf("%s: the shape is unknown", arr.String())
+	}
+	return ndarrayPosition{Strides: arr.Strides(), Offset: arr.Offset()}, nil
+}
+
+// newView creates the tensor which shares `Data` with `arr`.
+func (arr *NDArray) newView(shape []int, pos ndarrayPosition) *NDArray {
+	view := *arr
+	view.Shape = shape
+	view.view = &pos
+	view.source = 0
+	view.sourceURI = ""
+	view.position = ndarrayPosition{}
+	view.loader = nil
+	return &view
+}
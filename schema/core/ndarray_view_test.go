@@ -0,0 +1,239 @@
+package core
+
+import (
+	"encoding/binary"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newRangeArray creates the int32 tensor with the specified shape and the elements 0, 1, 2, ...
+func newRangeArray(shape ...int) *NDArray {
+	arr := &NDArray{DataType: types.Typ[types.Int32], ByteOrder: hbo, Shape: shape}
+	arr.Data = make([]byte, arr.CountBytes())
+	for i := 0; i < arr.CountElements(); i++ {
+		hbo.PutUint32(arr.Data[i*4:], uint32(i))
+	}
+	return arr
+}
+
+// int32s returns the elements of the view in C order.
+func int32s(req *require.Assertions, arr *NDArray) []int32 {
+	values, err := arr.Int32s()
+	req.NoError(err, arr.String())
+	return values
+}
+
+func TestNDArrayViews(t *testing.T) {
+	req := require.New(t)
+	arr := &NDArray{
+		DataType:  types.Typ[types.Float64],
+		ByteOrder: binary.BigEndian,
+		Shape:     []int{2, 3, 4},
+		Data:      make([]byte, 2*3*4*8),
+	}
+	for i := 0; i < 24; i++ {
+		req.NoError(arr.Set(float64(i), i/12, i/4%3, i%4))
+	}
+	req.Equal(-1, arr.Source())
+
+	view, err := arr.Slice(Range{Start: 1, Stop: 2}, Range{Start: -1, Stop: -4, Step: -2},
+		Range{Start: 1, Stop: 100, Step: 2})
+	req.NoError(err)
+	req.Equal(-1, view.Source())
+	req.Equal([]int{1, 2, 2}, view.Shape)
+	req.Equal([]int{96, -64, 16}, view.Strides())
+	req.Equal(8*21, view.Offset())
+	req.Same(&arr.Data[0], &view.Data[0])
+	doubles, err := view.Float64s()
+	req.NoError(err)
+	req.Equal([]float64{21, 23, 13, 15}, doubles)
+	req.NoError(view.Set(-1.0, 0, 1, 1))
+	value, err := arr.At(1, 0, 3)
+	req.NoError(err)
+	req.Equal(-1.0, value)
+
+	squeezed, err := view.Squeeze()
+	req.NoError(err)
+	req.Equal([]int{2, 2}, squeezed.Shape)
+	value, err = squeezed.At(1, 0)
+	req.NoError(err)
+	req.Equal(13.0, value)
+
+	transposed, err := arr.Transpose()
+	req.NoError(err)
+	req.Equal([]int{4, 3, 2}, transposed.Shape)
+	value, err = transposed.At(3, 1, 0)
+	req.NoError(err)
+	req.Equal(7.0, value)
+	transposed, err = arr.Transpose(0, 2, 1)
+	req.NoError(err)
+	value, err = transposed.At(1, 2, 0)
+	req.NoError(err)
+	req.Equal(14.0, value)
+	_, err = arr.Transpose(0, 0, 1)
+	req.Error(err)
+
+	// contiguous views share the data
+	reshaped, err := arr.Reshape(6, -1)
+	req.NoError(err)
+	req.Equal([]int{6, 4}, reshaped.Shape)
+	req.Same(&arr.Data[0], &reshaped.Data[0])
+	rows, err := arr.Slice(Range{Start: 1, Stop: 2})
+	req.NoError(err)
+	flat, err := rows.Reshape(-1)
+	req.NoError(err)
+	contiguous, err := flat.Contiguous()
+	req.NoError(err)
+	req.Same(&arr.Data[12*8], &contiguous.Data[0])
+	req.Len(contiguous.Data, 12*8)
+	// the rest are copied
+	reshaped, err = transposed.Reshape(2, 12)
+	req.NoError(err)
+	req.False(&arr.Data[0] == &reshaped.Data[0])
+	value, err = reshaped.At(0, 5)
+	req.NoError(err)
+	req.Equal(9.0, value)
+	_, err = arr.Reshape(5, -1)
+	req.Error(err)
+
+	contiguous, err = transposed.Contiguous()
+	req.NoError(err)
+	req.Equal([]int{96, 24, 8}, contiguous.Strides())
+	req.Equal(transposed.Shape, contiguous.Shape)
+	same, err := contiguous.Contiguous()
+	req.NoError(err)
+	req.Same(contiguous, same)
+
+	empty, err := arr.Slice(Range{}, FullRange, Range{Start: 2, Stop: 4})
+	req.NoError(err)
+	req.Equal([]int{0, 3, 2}, empty.Shape)
+	doubles, err = empty.Float64s()
+	req.NoError(err)
+	req.Empty(doubles)
+	_, err = arr.Slice(FullRange, FullRange, FullRange, FullRange)
+	req.Error(err)
+}
+
+func TestNDArrayViewsNegativeStrides(t *testing.T) {
+	req := require.New(t)
+	arr := newRangeArray(5)
+	reversed, err := arr.Slice(Range{Start: -1, Stop: -100, Step: -1})
+	req.NoError(err)
+	req.Equal([]int{5}, reversed.Shape)
+	req.Equal([]int{-4}, reversed.Strides())
+	req.Equal(16, reversed.Offset())
+	req.Same(&arr.Data[0], &reversed.Data[0])
+	req.Equal([]int32{4, 3, 2, 1, 0}, int32s(req, reversed))
+	contiguous, err := reversed.Contiguous()
+	req.NoError(err)
+	req.False(&arr.Data[0] == &contiguous.Data[0])
+	req.Equal([]int32{4, 3, 2, 1, 0}, int32s(req, contiguous))
+	// reversing twice restores the original layout
+	restored, err := reversed.Slice(Range{Start: -1, Stop: -100, Step: -1})
+	req.NoError(err)
+	req.Equal([]int{4}, restored.Strides())
+	req.Equal(0, restored.Offset())
+	contiguous, err = restored.Contiguous()
+	req.NoError(err)
+	req.Same(&arr.Data[0], &contiguous.Data[0])
+	// the start beyond the bounds is clamped
+	even, err := arr.Slice(Range{Start: 100, Stop: -100, Step: -2})
+	req.NoError(err)
+	req.Equal([]int32{4, 2, 0}, int32s(req, even))
+	value, err := even.At(2)
+	req.NoError(err)
+	req.Equal(int32(0), value)
+	req.NoError(even.Set(-1, 1))
+	value, err = arr.At(2)
+	req.NoError(err)
+	req.Equal(int32(-1), value)
+	reshaped, err := even.Reshape(3, 1)
+	req.NoError(err)
+	req.False(&arr.Data[0] == &reshaped.Data[0])
+	req.Equal([]int32{4, -1, 0}, int32s(req, reshaped))
+
+	matrix := newRangeArray(2, 3)
+	mirrored, err := matrix.Slice(FullRange, Range{Start: -1, Stop: -100, Step: -1})
+	req.NoError(err)
+	req.Equal([]int32{2, 1, 0, 5, 4, 3}, int32s(req, mirrored))
+	transposed, err := mirrored.Transpose()
+	req.NoError(err)
+	req.Equal([]int{-4, 12}, transposed.Strides())
+	req.Equal([]int32{2, 5, 1, 4, 0, 3}, int32s(req, transposed))
+	upsideDown, err := matrix.Slice(Range{Start: -1, Stop: -100, Step: -1}, Range{Start: 1, Stop: 2})
+	req.NoError(err)
+	squeezed, err := upsideDown.Squeeze()
+	req.NoError(err)
+	req.Equal([]int{2}, squeezed.Shape)
+	req.Equal([]int{-12}, squeezed.Strides())
+	req.Equal([]int32{4, 1}, int32s(req, squeezed))
+}
+
+func TestNDArrayViewsZeroLength(t *testing.T) {
+	req := require.New(t)
+	arr := newRangeArray(4, 3)
+	for _, r := range []Range{{}, {Start: 2, Stop: 2}, {Start: 3, Stop: 1},
+		{Start: 1, Stop: 3, Step: -1}, {Start: 100, Stop: 200}} {
+		empty, err := arr.Slice(r)
+		req.NoError(err, "%v", r)
+		req.Equal([]int{0, 3}, empty.Shape, "%v", r)
+		req.Equal(0, empty.Offset(), "%v", r)
+		req.Empty(int32s(req, empty), "%v", r)
+		contiguous, err := empty.Contiguous()
+		req.NoError(err)
+		req.Empty(contiguous.Data)
+		_, err = empty.At(0, 0)
+		req.Error(err)
+	}
+
+	arr = newRangeArray(0, 3)
+	column, err := arr.Slice(Range{Start: -1, Stop: -100, Step: -1}, Range{Start: 1, Stop: 2})
+	req.NoError(err)
+	req.Equal([]int{0, 1}, column.Shape)
+	req.Empty(int32s(req, column))
+	squeezed, err := column.Squeeze()
+	req.NoError(err)
+	req.Equal([]int{0}, squeezed.Shape)
+	transposed, err := arr.Transpose()
+	req.NoError(err)
+	req.Equal([]int{3, 0}, transposed.Shape)
+	req.Empty(int32s(req, transposed))
+	reshaped, err := arr.Reshape(3, 0, 5)
+	req.NoError(err)
+	req.Equal([]int{3, 0, 5}, reshaped.Shape)
+	reshaped, err = transposed.Reshape(-1)
+	req.NoError(err)
+	req.Equal([]int{0}, reshaped.Shape)
+	// the inferred dimension is ambiguous
+	_, err = arr.Reshape(0, -1)
+	req.Error(err)
+	_, err = arr.Reshape(1)
+	req.Error(err)
+}
+
+func TestNDArrayReshapeInvalid(t *testing.T) {
+	req := require.New(t)
+	arr := newRangeArray(2, 3, 4)
+	for _, shape := range [][]int{{}, {5}, {25}, {5, -1}, {7, -1}, {-1, -1}, {-2, -12},
+		{2, -3, -4}, {0, -1}, {24, 0}} {
+		_, err := arr.Reshape(shape...)
+		req.Error(err, "%v", shape)
+	}
+	reshaped, err := arr.Reshape(-1, 2, 3)
+	req.NoError(err)
+	req.Equal([]int{4, 2, 3}, reshaped.Shape)
+	scalar, err := newRangeArray(1, 1).Reshape()
+	req.NoError(err)
+	req.Equal([]int{}, scalar.Shape)
+	value, err := scalar.At()
+	req.NoError(err)
+	req.Equal(int32(0), value)
+	_, err = (&NDArray{}).Reshape(1)
+	req.EqualError(err, "the data type is not set")
+	streamed := newRangeArray(2, 3)
+	streamed.Shape = []int{-1, 3}
+	_, err = streamed.Reshape(-1)
+	req.Error(err)
+}
@@ -0,0 +1,329 @@
+package core
+
+import (
+	"github.com/src-d/go-asdf/schema"
+)
+
+// The abridged schemas of the core tags from the ASDF standard, see
+// https://github.com/spacetelescope/asdf-standard/tree/master/schemas/stsci.edu/asdf/core
+// They are not verbatim copies: the ids and the layout follow the originals, so that the JSON
+// pointers into them stay valid, but the examples, most descriptions and some of the properties
+// are left out, so the validation is less thorough than the reference one. The go-asdf specific
+// relaxations go to separate schemas which refer to these.
+
+const asdf100Schema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/asdf-1.0.0"
+tag: "tag:stsci.edu:asdf/core/asdf-1.0.0"
+title: Top-level schema for every ASDF file.
+type: object
+properties:
+  asdf_library:
+    description: Describes the ASDF library that produced the file.
+    $ref: "software-1.0.0"
+  history:
+    description: A log of transformations that have happened to the file.
+    type: array
+    items:
+      $ref: "history_entry-1.0.0"
+additionalProperties: true
+`
+
+const asdf110Schema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/asdf-1.1.0"
+tag: "tag:stsci.edu:asdf/core/asdf-1.1.0"
+title: Top-level schema for every ASDF file.
+type: object
+properties:
+  asdf_library:
+    description: Describes the ASDF library that produced the file.
+    $ref: "software-1.0.0"
+  history:
+    description: A log of transformations and the extensions used to create the file.
+    anyOf:
+      - $ref: "#/definitions/history-1.0.0"
+      - $ref: "#/definitions/history-1.1.0"
+definitions:
+  history-1.0.0:
+    type: array
+    items:
+      $ref: "history_entry-1.0.0"
+  history-1.1.0:
+    type: object
+    properties:
+      extensions:
+        type: array
+        items:
+          $ref: "extension_metadata-1.0.0"
+      entries:
+        type: array
+        items:
+          $ref: "history_entry-1.0.0"
+    additionalProperties: true
+additionalProperties: true
+`
+
+const softwareSchema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/software-1.0.0"
+tag: "tag:stsci.edu:asdf/core/software-1.0.0"
+title: Describes a software package.
+type: object
+properties:
+  name:
+    description: The name of the application or library.
+    type: string
+  author:
+    description: The author (or institution) that produced the software package.
+    type: string
+  homepage:
+    description: A URI to the homepage of the software.
+    type: string
+    format: uri
+  version:
+    description: The version of the software used.
+    type: string
+required: [name, version]
+additionalProperties: true
+`
+
+const historyEntrySchema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/history_entry-1.0.0"
+tag: "tag:stsci.edu:asdf/core/history_entry-1.0.0"
+title: An entry in the file history.
+type: object
+properties:
+  description:
+    description: A description of the transformation performed.
+    type: string
+  time:
+    description: A timestamp for the operation, in UTC.
+    type: string
+    format: date-time
+  software:
+    anyOf:
+      - $ref: "software-1.0.0"
+      - type: array
+        items:
+          $ref: "software-1.0.0"
+required: [description]
+additionalProperties: true
+`
+
+const extensionMetadataSchema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/extension_metadata-1.0.0"
+tag: "tag:stsci.edu:asdf/core/extension_metadata-1.0.0"
+title: Metadata about specific ASDF extensions that were used to create this file.
+type: object
+properties:
+  extension_class:
+    description: The fully-specified name of the extension class.
+    type: string
+  software:
+    $ref: "software-1.0.0"
+required: [extension_class]
+additionalProperties: true
+`
+
+const ndarraySchema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0"
+tag: "tag:stsci.edu:asdf/core/ndarray-1.0.0"
+title: An *n*-dimensional array.
+definitions:
+  scalar_datatype:
+    anyOf:
+      - type: string
+        enum: [int8, uint8, int16, uint16, int32, uint32, int64, uint64,
+               float32, float64, complex64, complex128, bool8]
+      - type: array
+        items:
+          - type: string
+            enum: [ascii, ucs4]
+          - type: integer
+            minimum: 0
+        minItems: 2
+        maxItems: 2
+  datatype:
+    anyOf:
+      - $ref: "#/definitions/scalar_datatype"
+      - type: array
+        items:
+          anyOf:
+            - $ref: "#/definitions/scalar_datatype"
+            - type: object
+              properties:
+                name:
+                  type: string
+                  pattern: "^[A-Za-z_][A-Za-z0-9_]*$"
+                byteorder:
+                  enum: [big, little]
+                datatype:
+                  $ref: "#/definitions/datatype"
+                shape:
+                  type: array
+                  items:
+                    type: integer
+                    minimum: 0
+              required: [datatype]
+  inline_data:
+    type: array
+    items:
+      anyOf:
+        - type: number
+        - type: string
+        - type: "null"
+        - type: boolean
+        - $ref: "#/definitions/inline_data"
+anyOf:
+  - $ref: "#/definitions/inline_data"
+  - type: object
+    properties:
+      source:
+        anyOf:
+          - type: integer
+          - type: string
+            format: uri
+      data:
+        $ref: "#/definitions/inline_data"
+      shape:
+        type: array
+        items:
+          anyOf:
+            - type: integer
+              minimum: 0
+            - enum: ["*"]
+      datatype:
+        $ref: "#/definitions/datatype"
+      byteorder:
+        enum: [big, little]
+      offset:
+        type: integer
+        minimum: 0
+      strides:
+        type: array
+        items:
+          anyOf:
+            - type: integer
+              minimum: 1
+            - type: integer
+              maximum: -1
+      mask:
+        anyOf:
+          - type: number
+          - type: string
+            enum: [NaN, Inf]
+          - $ref: "ndarray-1.0.0"
+    dependencies:
+      source: [shape, datatype, byteorder]
+`
+
+// ndarrayRelaxedSchemaID identifies ndarrayRelaxedSchema.
+const ndarrayRelaxedSchemaID = "http://github.com/src-d/go-asdf/schemas/core/ndarray-1.0.0"
+
+// ndarrayRelaxedSchema extends the standard ndarraySchema: go-asdf reads the inline scalars as
+// zero-dimensional arrays and supports the float16 data type, also in the structured fields.
+const ndarrayRelaxedSchema = `%YAML 1.1
+---
+$schema: "http://stsci.edu/schemas/yaml-schema/draft-01"
+id: "` + ndarrayRelaxedSchemaID + `"
+title: core/ndarray-1.0.0 which also allows the inline scalars and float16.
+definitions:
+  scalar_datatype:
+    anyOf:
+      - $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/definitions/scalar_datatype"
+      - type: string
+        enum: [float16]
+  datatype:
+    anyOf:
+      - $ref: "#/definitions/scalar_datatype"
+      - type: array
+        items:
+          anyOf:
+            - $ref: "#/definitions/scalar_datatype"
+            - type: object
+              properties:
+                name:
+                  type: string
+                  pattern: "^[A-Za-z_][A-Za-z0-9_]*$"
+                byteorder:
+                  enum: [big, little]
+                datatype:
+                  $ref: "#/definitions/datatype"
+                shape:
+                  type: array
+                  items:
+                    type: integer
+                    minimum: 0
+              required: [datatype]
+anyOf:
+  - $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0"
+  - type: object
+    properties:
+      data:
+        type: [number, string, boolean, "null"]
+      shape:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/shape"
+      datatype:
+        $ref: "#/definitions/datatype"
+      byteorder:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/byteorder"
+      mask:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/mask"
+    required: [data]
+    not:
+      required: [source]
+  - type: object
+    properties:
+      source:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/source"
+      data:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/data"
+      shape:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/shape"
+      datatype:
+        $ref: "#/definitions/datatype"
+      byteorder:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/byteorder"
+      offset:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/offset"
+      strides:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/strides"
+      mask:
+        $ref: "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/anyOf/1/properties/mask"
+    required: [datatype]
+    dependencies:
+      source: [shape, datatype, byteorder]
+`
+
+func init() {
+	for _, source := range []string{
+		asdf100Schema, asdf110Schema, softwareSchema, historyEntrySchema,
+		extensionMetadataSchema, ndarraySchema, ndarrayRelaxedSchema,
+	} {
+		schema.Schemas.MustAddSchema([]byte(source))
+	}
+	// the tag versions map to the schema ids
+	for name, id := range map[string]string{
+		"core/asdf-1.0.0":               "http://stsci.edu/schemas/asdf/core/asdf-1.0.0",
+		"core/asdf-1.1.0":               "http://stsci.edu/schemas/asdf/core/asdf-1.1.0",
+		"core/software-1.0.0":           "http://stsci.edu/schemas/asdf/core/software-1.0.0",
+		"core/history_entry-1.0.0":      "http://stsci.edu/schemas/asdf/core/history_entry-1.0.0",
+		"core/extension_metadata-1.0.0": "http://stsci.edu/schemas/asdf/core/extension_metadata-1.0.0",
+		"core/ndarray-1.0.0":            ndarrayRelaxedSchemaID,
+	} {
+		schema.Schemas.MustSetTagSchema(stsciPrefix+name, id)
+		if alias, exists := asdfFormatTags[name]; exists {
+			schema.Schemas.MustSetTagSchema(asdfFormatPrefix+alias, id)
+		}
+	}
+}
@@ -18,6 +18,8 @@ type Software struct {
 	Author string
 	// HomePage is the URL of the software.
 	HomePage string
+	// Extra contains the unknown properties which were kept in lenient mode.
+	Extra map[string]*yaml.Node
 }
 
 // Strings formats the object as a string.
@@ -33,6 +35,11 @@ func (lum softwareUnmarshaler) Version() semver.Version {
 }
 
 func (lum softwareUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return lum.UnmarshalASDF(value, &schema.Decoder{})
+}
+
+func (lum softwareUnmarshaler) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
 	if value.Kind != yaml.MappingNode {
 		return nil, errors.Errorf("tag core/software-%s requires a mapping node", lum.Version())
 	}
@@ -50,8 +57,8 @@ func (lum softwareUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, err
 		} else if key == "version" {
 			lib.Version, err = semver.Parse(text)
 		} else {
-			err = errors.Errorf("invalid key in a core/software-%s element: %s",
-				lum.Version(), key)
+			err = keepExtra(decoder, &lib.Extra, "core/software-"+lum.Version().String(), key,
+				value.Content[i])
 		}
 		if err != nil {
 			return nil, err
@@ -60,6 +67,49 @@ func (lum softwareUnmarshaler) UnmarshalYAML(value *yaml.Node) (interface{}, err
 	return lib, nil
 }
 
+// decodeSoftware converts the node tagged with core/software. The nodes with unsupported or
+// malformed tags, or with the tags of other definitions, are reported and returned as
+// TaggedValue in lenient mode.
+func decodeSoftware(node *yaml.Node, decoder *schema.Decoder) (
+	*Software, *schema.TaggedValue, error) {
+	tag, err := schema.ParseTag(node.Tag)
+	if err != nil {
+		return nil, &schema.TaggedValue{Tag: schema.Tag{Name: node.Tag}, Node: node},
+			decoder.Report("%v", err)
+	}
+	def := decoder.FindDefinition(tag)
+	if def == nil {
+		return nil, &schema.TaggedValue{Tag: tag, Node: node},
+			decoder.Report("unsupported tag: %s", tag.String())
+	}
+	obj, err := decoder.Unmarshal(def, node)
+	if err != nil {
+		return nil, nil, err
+	}
+	sw, ok := obj.(*Software)
+	if !ok {
+		return nil, &schema.TaggedValue{Tag: tag, Node: node},
+			decoder.Report("expected core/software, got %s", tag.String())
+	}
+	return sw, nil, nil
+}
+
+// MarshalASDF converts the object to a tagged YAML node.
+func (s Software) MarshalASDF(blocks schema.BlockAppender) (*yaml.Node, error) {
+	node := newMappingNode(softwareUnmarshaler{}.Version(), "core/software")
+	node.Style = yaml.FlowStyle
+	if s.Author != "" {
+		appendStringPair(node, "author", s.Author)
+	}
+	if s.HomePage != "" {
+		appendStringPair(node, "homepage", s.HomePage)
+	}
+	appendStringPair(node, "name", s.Name)
+	appendStringPair(node, "version", s.Version.String())
+	appendExtra(node, s.Extra)
+	return node, nil
+}
+
 func init() {
-	schema.Definitions["stsci.edu:asdf/core/software"] = []schema.Definition{softwareUnmarshaler{}}
+	registerCore("software", softwareUnmarshaler{})
 }
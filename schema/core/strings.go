@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go/types"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ASCII and UCS4 are the data types of the fixed-width string arrays, `[ascii, N]` and
+// `[ucs4, N]` in ASDF. N is `NDArray.StringLength`, the maximum number of characters in each
+// element; the shorter strings are padded with zeros. UCS4 characters are 32-bit code points in
+// `NDArray.ByteOrder`. Similar to Float16, the types are distinct copies of string in go/types
+// and must be compared by pointer.
+var (
+	ASCII = newStringType()
+	UCS4  = newStringType()
+)
+
+func newStringType() *types.Basic {
+	basic := *types.Typ[types.String]
+	return &basic
+}
+
+// isStringType checks whether the data type is ASCII or UCS4.
+func isStringType(dtype *types.Basic) bool {
+	return dtype == ASCII || dtype == UCS4
+}
+
+// Strings returns the elements of the ASCII or UCS4 tensor in C order without the trailing
+// zeros. The data is loaded if needed.
+func (arr *NDArray) Strings() ([]string, error) {
+	if !isStringType(arr.DataType) {
+		if arr.DataType == nil {
+			return nil, errors.New("the data type is not set")
+		}
+		return nil, errors.Errorf("%s: data type mismatch: requested a string", arr.String())
+	}
+	data, err := arr.loadedData()
+	if err != nil {
+		return nil, err
+	}
+	size := arr.ElementSize()
+	result := make([]string, arr.CountElements())
+	for i := range result {
+		result[i] = readString(data[i*size:(i+1)*size], arr.DataType, arr.ByteOrder)
+	}
+	return result, nil
+}
+
+// readString decodes a single element of the string tensor.
+func readString(data []byte, dtype *types.Basic, order binary.ByteOrder) string {
+	if dtype == ASCII {
+		return string(bytes.TrimRight(data, "\x00"))
+	}
+	runes := make([]rune, 0, len(data)/4)
+	for offset := 0; offset+4 <= len(data); offset += 4 {
+		runes = append(runes, rune(order.Uint32(data[offset:])))
+	}
+	for len(runes) > 0 && runes[len(runes)-1] == 0 {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
+
+// writeString is the inverse of readString. The string must fit into `out`, and ASCII strings
+// must not contain other characters.
+func writeString(out []byte, value string, dtype *types.Basic, order binary.ByteOrder) error {
+	if dtype == ASCII {
+		for i := 0; i < len(value); i++ {
+			if value[i] >= utf8.RuneSelf {
+				return errors.Errorf("%q is not ASCII", value)
+			}
+		}
+		if len(value) > len(out) {
+			return errors.Errorf("%q is longer than %d characters", value, len(out))
+		}
+		copy(out, value)
+		for i := len(value); i < len(out); i++ {
+			out[i] = 0
+		}
+		return nil
+	}
+	if count := utf8.RuneCountInString(value); count > len(out)/4 {
+		return errors.Errorf("%q is longer than %d characters", value, len(out)/4)
+	}
+	for i := range out {
+		out[i] = 0
+	}
+	offset := 0
+	for _, char := range value {
+		order.PutUint32(out[offset:], uint32(char))
+		offset += 4
+	}
+	return nil
+}
+
+// parseStringDataType recognizes `[ascii, N]` and `[ucs4, N]`.
+func parseStringDataType(node *yaml.Node) (*types.Basic, int, bool) {
+	if node.Kind != yaml.SequenceNode || len(node.Content) != 2 {
+		return nil, 0, false
+	}
+	length, err := strconv.Atoi(node.Content[1].Value)
+	if err != nil || length < 0 {
+		return nil, 0, false
+	}
+	switch node.Content[0].Value {
+	case "ascii":
+		return ASCII, length, true
+	case "ucs4":
+		return UCS4, length, true
+	}
+	return nil, 0, false
+}
+
+// stringDataTypeName formats the string data type as in ASDF.
+func stringDataTypeName(dtype *types.Basic) string {
+	if dtype == ASCII {
+		return "ascii"
+	}
+	return "ucs4"
+}
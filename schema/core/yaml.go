@@ -0,0 +1,97 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/blang/semver"
+	"gopkg.in/yaml.v3"
+
+	"github.com/src-d/go-asdf/schema"
+)
+
+const (
+	// stsciPrefix is the common part of all the tag names in the ASDF standard.
+	stsciPrefix = "tag:stsci.edu:asdf/"
+	// asdfFormatPrefix is the common part of the core tag names since ASDF standard 1.6.
+	asdfFormatPrefix = "asdf://asdf-format.org/core/tags/"
+)
+
+// newMappingNode creates an empty YAML mapping tagged with the specified ASDF standard schema.
+func newMappingNode(version semver.Version, name string) *yaml.Node {
+	tag := schema.Tag{Name: stsciPrefix + name, Version: version}
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: tag.String()}
+}
+
+// asdfFormatTags map the stsci.edu core tags to the asdf-format.org tags which ASDF standard 1.6
+// publishes for the same objects at the same versions. The top level core/asdf keeps
+// the stsci.edu tag in 1.6. ASDF standard 1.6 publishes only ndarray-1.1.0, which go-asdf does
+// not implement, so the asdf-format.org ndarray tag is not supported.
+var asdfFormatTags = map[string]string{
+	"core/extension_metadata-1.0.0": "extension_metadata-1.0.0",
+	"core/history_entry-1.0.0":      "history_entry-1.0.0",
+	"core/software-1.0.0":           "software-1.0.0",
+}
+
+// registerCore adds the global definition of the core tag with the stsci.edu URI and, if it is
+// published in ASDF standard 1.6, with the asdf-format.org URI and version. `name` is the short
+// name, e.g. "ndarray".
+func registerCore(name string, def schema.Definition) {
+	schema.DefaultRegistry.MustRegister(stsciPrefix+"core/"+name, def)
+	alias, exists := asdfFormatTags["core/"+name+"-"+def.Version().String()]
+	if !exists {
+		return
+	}
+	tag, err := schema.ParseTag(asdfFormatPrefix + alias)
+	if err != nil {
+		panic(err)
+	}
+	schema.DefaultRegistry.MustRegister(tag.Name, aliasDefinition{def, tag.Version})
+}
+
+// aliasDefinition registers the definition under a different version.
+type aliasDefinition struct {
+	schema.Definition
+	version semver.Version
+}
+
+func (ad aliasDefinition) Version() semver.Version {
+	return ad.version
+}
+
+func (ad aliasDefinition) UnmarshalASDF(value *yaml.Node, decoder *schema.Decoder) (
+	interface{}, error) {
+	return decoder.Unmarshal(ad.Definition, value)
+}
+
+func appendPair(mapping *yaml.Node, key string, value *yaml.Node) {
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+func appendStringPair(mapping *yaml.Node, key, value string) {
+	appendPair(mapping, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// appendExtra appends the unknown properties which were kept in lenient mode, sorted by key.
+func appendExtra(mapping *yaml.Node, extra map[string]*yaml.Node) {
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		appendPair(mapping, key, extra[key])
+	}
+}
+
+// keepExtra reports the unknown property and stores it in `extra` in lenient mode.
+func keepExtra(decoder *schema.Decoder, extra *map[string]*yaml.Node, tag, key string,
+	value *yaml.Node) error {
+	if err := decoder.Report("unknown property of %s: %s", tag, key); err != nil {
+		return err
+	}
+	if *extra == nil {
+		*extra = map[string]*yaml.Node{}
+	}
+	(*extra)[key] = value
+	return nil
+}
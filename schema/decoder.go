@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Decoder converts the tagged YAML nodes to objects.
+type Decoder struct {
+	// Registry contains the tag definitions. nil means the global `DefaultRegistry`.
+	Registry *Registry
+	// Strict turns every unsupported tag, key or data type into an error. Otherwise, the decoder
+	// is lenient: the unsupported nodes are kept as is, and the problems are only recorded in
+	// `Diagnostics`.
+	Strict bool
+	// Diagnostics lists the problems found so far.
+	Diagnostics []Diagnostic
+
+	// path is the location of the node which is being converted.
+	path []string
+}
+
+// Diagnostic is a problem found while converting the tree.
+type Diagnostic struct {
+	// Path is the location of the problem in the tree, e.g. "history.entries".
+	Path string
+	// Message describes the problem.
+	Message string
+}
+
+func (diag Diagnostic) String() string {
+	if diag.Path == "" {
+		return diag.Message
+	}
+	return diag.Path + ": " + diag.Message
+}
+
+// Report records the problem with the node which is being converted. It returns the error
+// in strict mode, and nil otherwise.
+func (decoder *Decoder) Report(format string, args ...interface{}) error {
+	decoder.Warn(format, args...)
+	if decoder.Strict {
+		return errors.New(decoder.Diagnostics[len(decoder.Diagnostics)-1].String())
+	}
+	return nil
+}
+
+// Warn records the problem with the node which is being converted. Unlike Report, it never fails,
+// even in strict mode.
+func (decoder *Decoder) Warn(format string, args ...interface{}) {
+	decoder.Diagnostics = append(decoder.Diagnostics, Diagnostic{
+		Path: strings.Join(decoder.path, "."), Message: fmt.Sprintf(format, args...)})
+}
+
+// Enter changes the location of the node which is being converted to the specified path
+// relative to the current one. The returned function restores the previous location.
+func (decoder *Decoder) Enter(path ...string) func() {
+	prev := decoder.path
+	decoder.path = append(prev[:len(prev):len(prev)], path...)
+	return func() {
+		decoder.path = prev
+	}
+}
+
+// FindDefinition returns the schema definition for the given tag, or nil if no such definition exist.
+// It warns if the exact version of the tag is not registered and a compatible one is chosen instead.
+func (decoder *Decoder) FindDefinition(tag Tag) Definition {
+	registry := decoder.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	def := registry.FindDefinition(tag)
+	if def != nil && !def.Version().EQ(tag.Version) {
+		decoder.Warn("%s is handled by the compatible version %s", tag.String(), def.Version())
+	}
+	return def
+}
+
+// Unmarshal turns the YAML node into an object with the specified definition. The decoder is
+// passed on if the definition is a DecoderDefinition.
+func (decoder *Decoder) Unmarshal(def Definition, value *yaml.Node) (interface{}, error) {
+	if ddef, ok := def.(DecoderDefinition); ok {
+		return ddef.UnmarshalASDF(value, decoder)
+	}
+	return def.UnmarshalYAML(value)
+}
+
+// Decode turns the tagged YAML node into an object. The tag must be supported.
+func (decoder *Decoder) Decode(value *yaml.Node) (interface{}, error) {
+	tag, err := ParseTag(value.Tag)
+	if err != nil {
+		return nil, err
+	}
+	def := decoder.FindDefinition(tag)
+	if def == nil {
+		return nil, errors.Errorf("unsupported tag: %s", tag.String())
+	}
+	return decoder.Unmarshal(def, value)
+}
@@ -1,8 +1,6 @@
 package schema
 
 import (
-	"sort"
-
 	"github.com/blang/semver"
 	"gopkg.in/yaml.v3"
 )
@@ -15,20 +13,43 @@ type Definition interface {
 	UnmarshalYAML(value *yaml.Node) (interface{}, error)
 }
 
-// Definitions is the list of all supported ASDF tags, sorted by `Version`.
+// Marshaler is implemented by the objects which serialize themselves to tagged YAML nodes.
+// It is the counterpart of `Definition.UnmarshalYAML`.
+type Marshaler interface {
+	// MarshalASDF turns the object into a YAML node. The binary data should be sent to `blocks`.
+	MarshalASDF(blocks BlockAppender) (*yaml.Node, error)
+}
+
+// BlockAppender accumulates the binary blocks while the tree is being serialized.
+type BlockAppender interface {
+	// AppendBlock schedules writing `data` as a binary block and returns the index of that block.
+	AppendBlock(data []byte) int
+	// AppendStreamedBlock schedules writing `data` as the streamed block which goes after all
+	// the others and returns the index of that block. There can be only one streamed block.
+	AppendStreamedBlock(data []byte) (int, error)
+}
+
+// DecoderDefinition is implemented by the definitions which convert the nested tagged nodes.
+// They receive the Decoder which carries the registry and the parsing mode.
+type DecoderDefinition interface {
+	Definition
+	// UnmarshalASDF turns a YAML node into an object, converting the nested nodes with `decoder`.
+	UnmarshalASDF(value *yaml.Node, decoder *Decoder) (interface{}, error)
+}
+
+// DefaultRegistry is the global registry of all supported ASDF tags. The extensions register
+// themselves in `init()` with `DefaultRegistry.MustRegister()`.
+var DefaultRegistry = &Registry{definitions: map[string][]Definition{}, legacy: Definitions}
+
+// Definitions is the former global list of the supported ASDF tags. The names do not include
+// the "tag:" prefix, e.g. "stsci.edu:asdf/core/ndarray". DefaultRegistry consults it after its
+// own definitions, and the ASDF version compatibility rules apply.
+//
+// Deprecated: register the definitions with `DefaultRegistry.MustRegister()` and the full
+// tag names, e.g. "tag:stsci.edu:asdf/core/ndarray".
 var Definitions = map[string][]Definition{}
 
 // FindDefinition returns the schema definition for the given tag, or nil if no such definition exist.
 func FindDefinition(tag Tag) Definition {
-	defs, exist := Definitions[tag.Name]
-	if !exist {
-		return nil
-	}
-	x := sort.Search(len(defs), func(i int) bool {
-		return defs[i].Version().GTE(tag.Version)
-	})
-	if x == len(defs) {
-		return nil
-	}
-	return defs[x]
+	return DefaultRegistry.FindDefinition(tag)
 }
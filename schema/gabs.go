@@ -1,7 +1,9 @@
 package schema
 
 import (
-	"log"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -16,31 +18,40 @@ type hnode struct {
 }
 
 // GabsifyYAML inserts the contents of a YAML node as another property of the JSON object, `key`.
+// The tags are resolved with the global `DefaultRegistry`.
 func GabsifyYAML(container *gabs.Container, root *yaml.Node, key string) error {
+	return (&Decoder{}).GabsifyYAML(container, root, key)
+}
+
+// GabsifyYAML inserts the contents of a YAML node as another property of the JSON object, `key`.
+// The nodes with unsupported or malformed tags are reported and inserted as TaggedValue.
+func (decoder *Decoder) GabsifyYAML(container *gabs.Container, root *yaml.Node, key string) error {
 	queue := []hnode{{[]string{key}, root}}
 	for len(queue) > 0 {
 		head := queue[len(queue)-1]
 		queue = queue[:len(queue)-1]
 		if head.Node.Tag != "" && !strings.HasPrefix(head.Node.Tag, "!!") {
+			var obj interface{}
+			restore := decoder.Enter(head.Path...)
 			tag, err := ParseTag(head.Node.Tag)
+			if err != nil {
+				obj = TaggedValue{Tag: Tag{Name: head.Node.Tag}, Node: head.Node}
+				err = decoder.Report("%v", err)
+			} else if def := decoder.FindDefinition(tag); def == nil {
+				obj = TaggedValue{Tag: tag, Node: head.Node}
+				err = decoder.Report("unsupported tag: %s", tag.String())
+			} else {
+				obj, err = decoder.Unmarshal(def, head.Node)
+			}
+			restore()
 			if err != nil {
 				return errors.Wrapf(err, "while converting %s", strings.Join(head.Path, "."))
 			}
-			def := FindDefinition(tag)
-			if def == nil {
-				log.Printf("unsupported tag at %s: %s", strings.Join(head.Path, "."),
-					tag.String())
-			} else {
-				obj, err := def.UnmarshalYAML(head.Node)
-				if err != nil {
-					return errors.Wrapf(err, "while converting %s", strings.Join(head.Path, "."))
-				}
-				_, err = container.Set(obj, head.Path...)
-				if err != nil {
-					return errors.Wrapf(err, "while converting %s", strings.Join(head.Path, "."))
-				}
-				continue
+			_, err = container.Set(obj, head.Path...)
+			if err != nil {
+				return errors.Wrapf(err, "while converting %s", strings.Join(head.Path, "."))
 			}
+			continue
 		}
 		switch head.Node.Kind {
 		case yaml.ScalarNode:
@@ -109,3 +120,103 @@ func GabsifyYAML(container *gabs.Container, root *yaml.Node, key string) error {
 	}
 	return nil
 }
+
+// YAMLifyGabs is the inverse of GabsifyYAML: it converts the JSON object model to a YAML node.
+// The objects which implement `Marshaler` serialize themselves and may append binary blocks.
+// *yaml.Node values are emitted as is.
+func YAMLifyGabs(container *gabs.Container, blocks BlockAppender) (*yaml.Node, error) {
+	return yamlifyValue(container.Data(), blocks, nil)
+}
+
+func yamlifyValue(value interface{}, blocks BlockAppender, path []string) (*yaml.Node, error) {
+	switch typed := value.(type) {
+	case Marshaler:
+		node, err := typed.MarshalASDF(blocks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while converting %s", strings.Join(path, "."))
+		}
+		return node, nil
+	case *yaml.Node:
+		return typed, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			sub, err := yamlifyValue(typed[key], blocks, append(path[:len(path):len(path)], key))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, sub)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i, elem := range typed {
+			sub, err := yamlifyValue(elem, blocks, append(path[:len(path):len(path)], strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			if sub.Kind != yaml.ScalarNode {
+				node.Style = 0
+			}
+			node.Content = append(node.Content, sub)
+		}
+		return node, nil
+	}
+	node, err := yamlifyScalar(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while converting %s", strings.Join(path, "."))
+	}
+	return node, nil
+}
+
+func yamlifyScalar(value interface{}) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.ScalarNode}
+	if value == nil {
+		node.Tag = "!!null"
+		node.Value = "null"
+		return node, nil
+	}
+	switch reflected := reflect.ValueOf(value); reflected.Kind() {
+	case reflect.String:
+		node.Tag = "!!str"
+		node.Value = reflected.String()
+	case reflect.Bool:
+		node.Tag = "!!bool"
+		node.Value = strconv.FormatBool(reflected.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		node.Tag = "!!int"
+		node.Value = strconv.FormatInt(reflected.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		node.Tag = "!!int"
+		node.Value = strconv.FormatUint(reflected.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		node.Tag = "!!float"
+		node.Value = formatFloat(reflected.Float())
+	default:
+		return nil, errors.Errorf("unsupported value type: %s", reflected.Type())
+	}
+	return node, nil
+}
+
+// formatFloat writes a floating point number so that it is not confused with an integer
+// when parsed back.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return ".nan"
+	case math.IsInf(value, 1):
+		return ".inf"
+	case math.IsInf(value, -1):
+		return "-.inf"
+	}
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return text
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+)
+
+// Registry maps the tag names to the corresponding definitions of each version.
+// It is safe for concurrent use.
+type Registry struct {
+	lock sync.RWMutex
+	// definitions are sorted by `Version`.
+	definitions map[string][]Definition
+	// legacy is the deprecated global `Definitions` map. Only DefaultRegistry consults it.
+	legacy map[string][]Definition
+}
+
+// NewRegistry creates an empty Registry. Pass it to `Decoder` to isolate the extensions
+// from the global `DefaultRegistry`.
+func NewRegistry() *Registry {
+	return &Registry{definitions: map[string][]Definition{}}
+}
+
+// Register adds the definition of the tag with the specified name, e.g.
+// "tag:stsci.edu:asdf/core/ndarray". It fails if the same version of the tag is already registered.
+func (registry *Registry) Register(name string, def Definition) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	defs := registry.definitions[name]
+	version := def.Version()
+	x := sort.Search(len(defs), func(i int) bool {
+		return defs[i].Version().GTE(version)
+	})
+	if x < len(defs) && defs[x].Version().EQ(version) {
+		return errors.Errorf("tag %s-%s is already registered", name, version)
+	}
+	defs = append(defs, nil)
+	copy(defs[x+1:], defs[x:])
+	defs[x] = def
+	registry.definitions[name] = defs
+	return nil
+}
+
+// MustRegister is the same as Register, but panics on errors. It is intended for `init()`.
+func (registry *Registry) MustRegister(name string, def Definition) {
+	if err := registry.Register(name, def); err != nil {
+		panic(err)
+	}
+}
+
+// Clone returns the independent copy of the registry. The definitions themselves are shared.
+// The clone of DefaultRegistry includes the definitions from the deprecated `Definitions`.
+func (registry *Registry) Clone() *Registry {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	clone := NewRegistry()
+	for name, defs := range registry.definitions {
+		clone.definitions[name] = append([]Definition{}, defs...)
+	}
+	for name := range registry.legacy {
+		clone.definitions[legacyPrefix+name] = registry.lookup(legacyPrefix + name)
+	}
+	return clone
+}
+
+// Versions returns the registered definitions of the tag with the specified name, sorted by
+// `Version`.
+func (registry *Registry) Versions(name string) []Definition {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.lookup(name)
+}
+
+// legacyPrefix is missing in the names of the tags in the deprecated `Definitions`.
+const legacyPrefix = "tag:"
+
+// lookup returns the copy of the definitions of the tag with the specified name, including
+// the deprecated `Definitions` for DefaultRegistry, sorted by `Version`. The registered
+// definitions go first if the versions are equal. The caller must hold the lock.
+func (registry *Registry) lookup(name string) []Definition {
+	defs := append([]Definition{}, registry.definitions[name]...)
+	if registry.legacy == nil || !strings.HasPrefix(name, legacyPrefix) {
+		return defs
+	}
+	legacy := registry.legacy[name[len(legacyPrefix):]]
+	if len(legacy) == 0 {
+		return defs
+	}
+	defs = append(defs, legacy...)
+	sort.SliceStable(defs, func(i, j int) bool {
+		return defs[i].Version().LT(defs[j].Version())
+	})
+	return defs
+}
+
+// FindDefinition returns the schema definition for the given tag, or nil if no such definition exist.
+// The exact version is preferred; otherwise, the ASDF compatibility rules apply: the highest
+// registered version with the same major and the minor not greater than the requested one is chosen.
+// E.g., 1.1.0 falls back to 1.0.0 but neither 1.0.0 to 1.1.0 nor 2.0.0 to 1.0.0.
+func (registry *Registry) FindDefinition(tag Tag) Definition {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	defs := registry.lookup(tag.Name)
+	versions := make([]semver.Version, len(defs))
+	for i, def := range defs {
+		versions[i] = def.Version()
+	}
+	if i := findCompatible(versions, tag.Version); i >= 0 {
+		return defs[i]
+	}
+	return nil
+}
+
+// findCompatible returns the index of the version which handles the requested one by the ASDF
+// compatibility rules, see Registry.FindDefinition(), or -1 if there is no such version.
+// The first of the equal versions wins.
+func findCompatible(versions []semver.Version, requested semver.Version) int {
+	best := -1
+	for i, version := range versions {
+		if version.EQ(requested) {
+			return i
+		}
+		if version.Major == requested.Major && version.Minor <= requested.Minor &&
+			(best < 0 || version.GT(versions[best])) {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,146 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type versionDefinition string
+
+func (vd versionDefinition) Version() semver.Version {
+	return semver.MustParse(string(vd))
+}
+
+func (vd versionDefinition) UnmarshalYAML(value *yaml.Node) (interface{}, error) {
+	return string(vd), nil
+}
+
+func registryVersions(registry *Registry, name string) []string {
+	var versions []string
+	for _, def := range registry.Versions(name) {
+		versions = append(versions, def.Version().String())
+	}
+	return versions
+}
+
+func TestRegistryRegister(t *testing.T) {
+	req := require.New(t)
+	registry := NewRegistry()
+	for _, version := range []string{"1.1.0", "2.0.0", "1.0.0", "1.10.0", "1.2.0"} {
+		req.NoError(registry.Register("tag:example.com:foo", versionDefinition(version)))
+	}
+	req.Equal([]string{"1.0.0", "1.1.0", "1.2.0", "1.10.0", "2.0.0"},
+		registryVersions(registry, "tag:example.com:foo"))
+	req.Error(registry.Register("tag:example.com:foo", versionDefinition("1.2.0")))
+	req.Len(registry.Versions("tag:example.com:foo"), 5)
+	req.NoError(registry.Register("tag:example.com:bar", versionDefinition("1.2.0")))
+	req.Panics(func() {
+		registry.MustRegister("tag:example.com:bar", versionDefinition("1.2.0"))
+	})
+	req.Empty(registry.Versions("tag:example.com:baz"))
+}
+
+func TestRegistryClone(t *testing.T) {
+	req := require.New(t)
+	registry := NewRegistry()
+	registry.MustRegister("tag:example.com:foo", versionDefinition("1.0.0"))
+	clone := registry.Clone()
+	clone.MustRegister("tag:example.com:foo", versionDefinition("1.1.0"))
+	clone.MustRegister("tag:example.com:bar", versionDefinition("1.0.0"))
+	req.Equal([]string{"1.0.0"}, registryVersions(registry, "tag:example.com:foo"))
+	req.Empty(registry.Versions("tag:example.com:bar"))
+	req.Equal([]string{"1.0.0", "1.1.0"}, registryVersions(clone, "tag:example.com:foo"))
+	// the same version may be registered in different registries
+	registry.MustRegister("tag:example.com:bar", versionDefinition("1.0.0"))
+}
+
+func TestDecoderRegistry(t *testing.T) {
+	req := require.New(t)
+	registry := NewRegistry()
+	registry.MustRegister("tag:example.com:foo", versionDefinition("1.0.0"))
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "tag:example.com:foo-1.0.0", Value: "x"}
+	obj, err := (&Decoder{Registry: registry}).Decode(node)
+	req.NoError(err)
+	req.Equal("1.0.0", obj)
+	_, err = (&Decoder{}).Decode(node)
+	req.Error(err)
+}
+
+func TestRegistryFindDefinition(t *testing.T) {
+	req := require.New(t)
+	registry := NewRegistry()
+	for _, version := range []string{"1.0.0", "1.2.0", "1.2.3", "2.1.0"} {
+		registry.MustRegister("tag:example.com:foo", versionDefinition(version))
+	}
+	matrix := []struct {
+		requested, found string
+	}{
+		{"1.0.0", "1.0.0"},
+		{"1.0.5", "1.0.0"},
+		{"1.1.0", "1.0.0"},
+		{"1.2.0", "1.2.0"},
+		{"1.2.1", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		{"1.3.5", "1.2.3"},
+		{"0.9.0", ""},
+		{"2.0.0", ""},
+		{"2.1.0", "2.1.0"},
+		{"2.5.0", "2.1.0"},
+		{"3.0.0", ""},
+	}
+	for _, item := range matrix {
+		def := registry.FindDefinition(Tag{"tag:example.com:foo", semver.MustParse(item.requested)})
+		if item.found == "" {
+			req.Nil(def, item.requested)
+			continue
+		}
+		req.NotNil(def, item.requested)
+		req.Equal(item.found, def.Version().String(), item.requested)
+	}
+	req.Nil(registry.FindDefinition(Tag{"tag:example.com:bar", semver.MustParse("1.0.0")}))
+}
+
+func TestDecoderFindDefinitionWarning(t *testing.T) {
+	req := require.New(t)
+	registry := NewRegistry()
+	registry.MustRegister("tag:example.com:foo", versionDefinition("1.0.0"))
+	decoder := &Decoder{Registry: registry, Strict: true}
+	req.NotNil(decoder.FindDefinition(Tag{"tag:example.com:foo", semver.MustParse("1.0.0")}))
+	req.Empty(decoder.Diagnostics)
+	restore := decoder.Enter("tree", "foo")
+	req.NotNil(decoder.FindDefinition(Tag{"tag:example.com:foo", semver.MustParse("1.1.0")}))
+	restore()
+	req.Equal([]Diagnostic{{
+		Path: "tree.foo", Message: "tag:example.com:foo-1.1.0 is handled by the compatible version 1.0.0",
+	}}, decoder.Diagnostics)
+	req.Nil(decoder.FindDefinition(Tag{"tag:example.com:foo", semver.MustParse("2.0.0")}))
+	req.Len(decoder.Diagnostics, 1)
+}
+
+func TestDefaultRegistryLegacyDefinitions(t *testing.T) {
+	req := require.New(t)
+	Definitions["example.com:legacy"] = []Definition{versionDefinition("1.0.0")}
+	defer delete(Definitions, "example.com:legacy")
+	DefaultRegistry.MustRegister("tag:example.com:legacy", versionDefinition("1.2.0"))
+	defer func() {
+		DefaultRegistry.lock.Lock()
+		delete(DefaultRegistry.definitions, "tag:example.com:legacy")
+		DefaultRegistry.lock.Unlock()
+	}()
+	req.Equal([]string{"1.0.0", "1.2.0"}, registryVersions(DefaultRegistry, "tag:example.com:legacy"))
+	def := FindDefinition(Tag{"tag:example.com:legacy", semver.MustParse("1.1.0")})
+	req.NotNil(def)
+	req.Equal("1.0.0", def.Version().String())
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "tag:example.com:legacy-1.0.0", Value: "x"}
+	obj, err := (&Decoder{}).Decode(node)
+	req.NoError(err)
+	req.Equal("1.0.0", obj)
+	clone := DefaultRegistry.Clone()
+	req.Equal([]string{"1.0.0", "1.2.0"}, registryVersions(clone, "tag:example.com:legacy"))
+	// only the default registry consults the legacy map
+	req.Empty(NewRegistry().Versions("tag:example.com:legacy"))
+	req.Empty(DefaultRegistry.Versions("example.com:legacy"))
+}
@@ -9,28 +9,36 @@ import (
 
 // Tag represents a versioned entity such as an ASDF tag.
 type Tag struct {
+	// Name is the full URI of the tag without the version, e.g. "tag:stsci.edu:asdf/core/ndarray"
+	// or "asdf://asdf-format.org/core/tags/ndarray".
 	Name    string
 	Version semver.Version
 }
 
-// ParseTag parses the ASDF tag from a string.
+// ParseTag parses the ASDF tag from a resolved URI string. The YAML parser expands the handles
+// declared with %TAG directives, so the local tags which remain, e.g. "!foo-1.0.0",
+// are rejected. The version follows the last dash and must be a valid semantic version.
 func ParseTag(str string) (Tag, error) {
-	dashPos := strings.IndexRune(str, '-')
+	if !strings.HasPrefix(str, "tag:") && !strings.Contains(str, "://") {
+		return Tag{}, errors.Errorf("cannot parse tag: \"%s\": not a URI, is %%TAG missing?", str)
+	}
+	dashPos := strings.LastIndexByte(str, '-')
 	if dashPos < 0 {
 		return Tag{}, errors.Errorf("cannot parse tag: \"%s\": no version separator (dash)",
 			str)
 	}
 	name := str[:dashPos]
-	if strings.HasPrefix(name, "tag:") {
-		name = name[4:]
+	if strings.HasSuffix(name, ":") || strings.HasSuffix(name, "/") {
+		return Tag{}, errors.Errorf("cannot parse tag: \"%s\": empty name", str)
 	}
-	version, err := semver.Make(str[dashPos+1:])
+	version, err := semver.Parse(str[dashPos+1:])
 	if err != nil {
-		return Tag{}, err
+		return Tag{}, errors.Wrapf(err, "cannot parse tag: \"%s\": invalid version", str)
 	}
 	return Tag{name, version}, nil
 }
 
+// String returns the full tag URI, e.g. "tag:stsci.edu:asdf/core/ndarray-1.0.0".
 func (tag Tag) String() string {
 	return tag.Name + "-" + tag.Version.String()
 }
@@ -0,0 +1,34 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTag(t *testing.T) {
+	req := require.New(t)
+	valid := []struct {
+		str, name, version string
+	}{
+		{"tag:stsci.edu:asdf/core/ndarray-1.0.0", "tag:stsci.edu:asdf/core/ndarray", "1.0.0"},
+		{"tag:example-org.com:foo-1.0.0", "tag:example-org.com:foo", "1.0.0"},
+		{"tag:example.com:foo-bar-2.1.3", "tag:example.com:foo-bar", "2.1.3"},
+		{"asdf://asdf-format.org/core/tags/ndarray-1.1.0",
+			"asdf://asdf-format.org/core/tags/ndarray", "1.1.0"},
+	}
+	for _, item := range valid {
+		tag, err := ParseTag(item.str)
+		req.NoError(err, item.str)
+		req.Equal(Tag{item.name, semver.MustParse(item.version)}, tag)
+		req.Equal(item.str, tag.String())
+	}
+	for _, str := range []string{
+		"!core/ndarray-1.0.0", "tag:stsci.edu:asdf/core/ndarray", "tag:example.com:foo-1.0",
+		"tag:example.com:foo-bar", "tag:-1.0.0", "asdf://asdf-format.org/-1.0.0", "!!str",
+	} {
+		_, err := ParseTag(str)
+		req.Error(err, str)
+	}
+}
@@ -0,0 +1,21 @@
+package schema
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// TaggedValue is the node with an unsupported tag, e.g. of a third party extension. GabsifyYAML
+// inserts it into the tree instead of the converted object. The nested nodes are not converted,
+// so the writer does not preserve the binary blocks referenced by the nested arrays.
+type TaggedValue struct {
+	// Tag is the parsed tag of the node. If the tag cannot be parsed, e.g. a local tag without
+	// the version, Name is the raw tag and Version is zero.
+	Tag Tag
+	// Node is the raw YAML node, including the nested nodes.
+	Node *yaml.Node
+}
+
+// MarshalASDF returns the raw node unchanged.
+func (tv TaggedValue) MarshalASDF(blocks BlockAppender) (*yaml.Node, error) {
+	return tv.Node, nil
+}
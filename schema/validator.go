@@ -0,0 +1,775 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// maxRefDepth limits the nesting of `$ref`-s to detect the infinite recursion.
+const maxRefDepth = 64
+
+// Validator checks YAML trees against JSON schemas (draft 4) which are extended with
+// the ASDF keywords `tag`, `ndim`, `max_ndim` and `datatype`. The schemas are never downloaded:
+// `$ref` may only point to the schemas which were added before. It is safe for concurrent use.
+type Validator struct {
+	lock sync.RWMutex
+	// schemas map the schema ids to the parsed documents.
+	schemas map[string]*yaml.Node
+	// tags map the tag URIs to the schema ids.
+	tags map[string]string
+}
+
+// Schemas is the global validator with the abridged core schemas of the ASDF standard, see
+// package core. The extensions add their schemas in `init()`.
+var Schemas = NewValidator()
+
+// NewValidator creates a Validator without any schemas.
+func NewValidator() *Validator {
+	return &Validator{schemas: map[string]*yaml.Node{}, tags: map[string]string{}}
+}
+
+// AddSchema parses the YAML-encoded schema and adds it to the validator. The schema must have
+// an absolute `id` which the others may refer to. The top level `tag`, if present, declares that
+// the nodes with that tag are validated against the schema.
+func (validator *Validator) AddSchema(source []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(source, &doc); err != nil {
+		return errors.Wrap(err, "failed to parse the schema")
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("the schema must be a mapping")
+	}
+	root := doc.Content[0]
+	id := mappingValue(root, "id")
+	if id == nil || id.Value == "" {
+		return errors.New("the schema does not have an id")
+	}
+	if uri, err := url.Parse(id.Value); err != nil || !uri.IsAbs() || uri.Fragment != "" {
+		return errors.Errorf("the schema id must be an absolute URI: %s", id.Value)
+	}
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+	if _, exists := validator.schemas[id.Value]; exists {
+		return errors.Errorf("schema %s is already added", id.Value)
+	}
+	if tag := mappingValue(root, "tag"); tag != nil {
+		if prev, exists := validator.tags[tag.Value]; exists {
+			return errors.Errorf("tag %s is already described by %s", tag.Value, prev)
+		}
+		validator.tags[tag.Value] = id.Value
+	}
+	validator.schemas[id.Value] = root
+	return nil
+}
+
+// MustAddSchema is the same as AddSchema, but panics on errors. It is intended for `init()`.
+func (validator *Validator) MustAddSchema(source []byte) {
+	if err := validator.AddSchema(source); err != nil {
+		panic(err)
+	}
+}
+
+// SetTagSchema declares that the nodes with the specified tag are validated against the schema
+// with the specified id, replacing the previous declaration. It allows the aliases of the tags,
+// e.g. "asdf://asdf-format.org/core/tags/ndarray-1.0.0", and the local extensions which refer
+// to the intact standard schemas with `$ref`.
+func (validator *Validator) SetTagSchema(tag, id string) error {
+	validator.lock.Lock()
+	defer validator.lock.Unlock()
+	if _, exists := validator.schemas[id]; !exists {
+		return errors.Errorf("unknown schema %s", id)
+	}
+	validator.tags[tag] = id
+	return nil
+}
+
+// MustSetTagSchema is the same as SetTagSchema, but panics on errors. It is intended for `init()`.
+func (validator *Validator) MustSetTagSchema(tag, id string) {
+	if err := validator.SetTagSchema(tag, id); err != nil {
+		panic(err)
+	}
+}
+
+// findSchema returns the id of the schema which describes the nodes with the specified tag.
+// The versions are resolved by the same rules as in Registry: if the exact version is not
+// declared, the highest compatible one is chosen. The caller must hold the lock.
+func (validator *Validator) findSchema(tag string) (string, bool) {
+	if id, exists := validator.tags[tag]; exists {
+		return id, true
+	}
+	parsed, err := ParseTag(tag)
+	if err != nil {
+		return "", false
+	}
+	var versions []semver.Version
+	var ids []string
+	for candidate, id := range validator.tags {
+		if parsedCandidate, err := ParseTag(candidate); err == nil &&
+			parsedCandidate.Name == parsed.Name {
+			versions = append(versions, parsedCandidate.Version)
+			ids = append(ids, id)
+		}
+	}
+	if i := findCompatible(versions, parsed.Version); i >= 0 {
+		return ids[i], true
+	}
+	return "", false
+}
+
+// Validate checks every node in the tree whose tag has a schema. The tag versions are resolved
+// by the ASDF compatibility rules, see Registry.FindDefinition. The nodes with other tags are not checked,
+// except by the schemas of their parents. The returned error is ValidationErrors.
+func (validator *Validator) Validate(tree *yaml.Node) error {
+	validator.lock.RLock()
+	defer validator.lock.RUnlock()
+	state := &validation{validator: validator}
+	var errs ValidationErrors
+	seen := map[string]bool{}
+	var walk func(node *yaml.Node, path []string)
+	walk = func(node *yaml.Node, path []string) {
+		if id, exists := validator.findSchema(node.Tag); exists {
+			loc := schemaLocation{id: id, node: validator.schemas[id]}
+			for _, err := range state.check(node, path, loc) {
+				// the nested tagged nodes can be checked twice: by themselves and by the parents
+				if key := err.Error(); !seen[key] {
+					seen[key] = true
+					errs = append(errs, err)
+				}
+			}
+		}
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for i, child := range node.Content {
+				if node.Kind == yaml.SequenceNode {
+					walk(child, appendPath(path, strconv.Itoa(i)))
+				} else {
+					walk(child, path)
+				}
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(node.Content); i += 2 {
+				walk(node.Content[i], appendPath(path, node.Content[i-1].Value))
+			}
+		}
+	}
+	walk(tree, nil)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidationError is the failed check of a node.
+type ValidationError struct {
+	// Path is the location of the invalid node in the tree, e.g. "arr.shape.0".
+	Path string
+	// Rule is the URI of the violated schema keyword, e.g.
+	// "http://stsci.edu/schemas/asdf/core/ndarray-1.0.0#/properties/byteorder/enum".
+	Rule string
+	// Message describes the problem.
+	Message string
+}
+
+func (err ValidationError) Error() string {
+	if err.Path == "" {
+		return fmt.Sprintf("%s (%s)", err.Message, err.Rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", err.Path, err.Message, err.Rule)
+}
+
+// ValidationErrors lists all the failed checks of a tree.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d validation error(s):\n%s", len(errs), strings.Join(lines, "\n"))
+}
+
+// schemaLocation points at the (sub)schema inside a schema document.
+type schemaLocation struct {
+	// id is the URI of the schema document.
+	id string
+	// pointer is the JSON pointer to the subschema, empty for the whole document.
+	pointer string
+	node    *yaml.Node
+}
+
+func (loc schemaLocation) child(node *yaml.Node, keys ...string) schemaLocation {
+	pointer := loc.pointer
+	for _, key := range keys {
+		pointer += "/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+	}
+	return schemaLocation{id: loc.id, pointer: pointer, node: node}
+}
+
+func (loc schemaLocation) rule(keyword string) string {
+	if keyword != "" {
+		loc = loc.child(nil, keyword)
+	}
+	return loc.id + "#" + loc.pointer
+}
+
+// validation is the state of a single Validator.Validate() call.
+type validation struct {
+	validator *Validator
+	depth     int
+}
+
+// check validates the node against the schema and returns the failed checks.
+func (state *validation) check(
+	node *yaml.Node, path []string, loc schemaLocation) ValidationErrors {
+	node = resolveAlias(node)
+	fail := func(keyword, format string, args ...interface{}) ValidationErrors {
+		return ValidationErrors{{
+			Path:    strings.Join(path, "."),
+			Rule:    loc.rule(keyword),
+			Message: fmt.Sprintf(format, args...),
+		}}
+	}
+	if loc.node.Kind != yaml.MappingNode {
+		return fail("", "invalid schema")
+	}
+	if ref := mappingValue(loc.node, "$ref"); ref != nil {
+		// all the other keywords are ignored in draft 4
+		target, err := state.validator.resolve(loc, ref.Value)
+		if err != nil {
+			return fail("$ref", "%v", err)
+		}
+		if state.depth >= maxRefDepth {
+			return fail("$ref", "too deeply nested references")
+		}
+		state.depth++
+		defer func() { state.depth-- }()
+		return state.check(node, path, target)
+	}
+	var errs ValidationErrors
+	for i := 1; i < len(loc.node.Content); i += 2 {
+		keyword := loc.node.Content[i-1].Value
+		value := resolveAlias(loc.node.Content[i])
+		switch keyword {
+		case "type":
+			if !matchesType(node, value) {
+				errs = append(errs, fail(keyword, "expected %s, got %s",
+					describeValue(value), nodeType(node))...)
+			}
+		case "enum":
+			actual := plainValue(node)
+			found := false
+			for _, item := range value.Content {
+				if reflect.DeepEqual(actual, plainValue(item)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs = append(errs, fail(keyword, "%s is not one of %s",
+					describeValue(node), describeValue(value))...)
+			}
+		case "properties", "patternProperties", "additionalProperties", "required",
+			"dependencies", "minProperties", "maxProperties":
+			if node.Kind == yaml.MappingNode {
+				errs = append(errs, state.checkObject(node, path, loc, keyword, value, fail)...)
+			}
+		case "items", "additionalItems", "minItems", "maxItems", "uniqueItems":
+			if node.Kind == yaml.SequenceNode {
+				errs = append(errs, state.checkArray(node, path, loc, keyword, value, fail)...)
+			}
+		case "minimum", "maximum", "multipleOf":
+			number, ok := numberValue(node)
+			limit, limitOk := numberValue(value)
+			if !ok || !limitOk {
+				continue
+			}
+			exclusive := false
+			switch keyword {
+			case "minimum":
+				exclusive = isTrue(mappingValue(loc.node, "exclusiveMinimum"))
+				if number < limit || (exclusive && number == limit) {
+					errs = append(errs, fail(keyword, "%v is less than %v", number, limit)...)
+				}
+			case "maximum":
+				exclusive = isTrue(mappingValue(loc.node, "exclusiveMaximum"))
+				if number > limit || (exclusive && number == limit) {
+					errs = append(errs, fail(keyword, "%v is greater than %v", number, limit)...)
+				}
+			case "multipleOf":
+				if limit > 0 && math.Abs(math.Remainder(number, limit)) > 1e-9 {
+					errs = append(errs, fail(keyword, "%v is not a multiple of %v", number, limit)...)
+				}
+			}
+		case "minLength", "maxLength", "pattern":
+			if nodeType(node) != "string" {
+				continue
+			}
+			length := utf8.RuneCountInString(node.Value)
+			switch keyword {
+			case "minLength":
+				if limit, ok := numberValue(value); ok && float64(length) < limit {
+					errs = append(errs, fail(keyword, "%q is shorter than %v", node.Value, limit)...)
+				}
+			case "maxLength":
+				if limit, ok := numberValue(value); ok && float64(length) > limit {
+					errs = append(errs, fail(keyword, "%q is longer than %v", node.Value, limit)...)
+				}
+			case "pattern":
+				re, err := regexp.Compile(value.Value)
+				if err != nil {
+					errs = append(errs, fail(keyword, "invalid pattern: %v", err)...)
+				} else if !re.MatchString(node.Value) {
+					errs = append(errs, fail(keyword, "%q does not match %s", node.Value, value.Value)...)
+				}
+			}
+		case "allOf":
+			for j, sub := range value.Content {
+				errs = append(errs, state.check(node, path, loc.child(sub, keyword, strconv.Itoa(j)))...)
+			}
+		case "anyOf", "oneOf":
+			matched := 0
+			for j, sub := range value.Content {
+				if len(state.check(node, path, loc.child(sub, keyword, strconv.Itoa(j)))) == 0 {
+					matched++
+				}
+			}
+			if matched == 0 {
+				errs = append(errs, fail(keyword, "does not match any of the %d alternatives",
+					len(value.Content))...)
+			} else if keyword == "oneOf" && matched > 1 {
+				errs = append(errs, fail(keyword, "matches %d alternatives instead of one", matched)...)
+			}
+		case "not":
+			if len(state.check(node, path, loc.child(value, keyword))) == 0 {
+				errs = append(errs, fail(keyword, "matches the forbidden schema")...)
+			}
+		case "tag":
+			// the top level tag declares which nodes the schema describes, see AddSchema()
+			if loc.pointer != "" && !matchesTag(node.Tag, value.Value) {
+				actual := node.Tag
+				if actual == "" || strings.HasPrefix(actual, "!!") {
+					actual = "no tag"
+				}
+				errs = append(errs, fail(keyword, "expected tag %s, got %s", value.Value, actual)...)
+			}
+		case "ndim", "max_ndim":
+			ndim, ok := arrayDimensions(node)
+			limit, limitOk := numberValue(value)
+			if !ok || !limitOk {
+				continue
+			}
+			if keyword == "ndim" && float64(ndim) != limit {
+				errs = append(errs, fail(keyword, "expected %v dimensions, got %d", limit, ndim)...)
+			} else if keyword == "max_ndim" && float64(ndim) > limit {
+				errs = append(errs, fail(keyword, "expected at most %v dimensions, got %d",
+					limit, ndim)...)
+			}
+		case "datatype":
+			var dtype *yaml.Node
+			if node.Kind == yaml.MappingNode {
+				dtype = mappingValue(node, "datatype")
+			}
+			if dtype == nil {
+				continue
+			}
+			if reflect.DeepEqual(plainValue(dtype), plainValue(value)) {
+				continue
+			}
+			if !isTrue(mappingValue(loc.node, "exact_datatype")) &&
+				dtype.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode &&
+				canCastDataType(dtype.Value, value.Value) {
+				continue
+			}
+			errs = append(errs, fail(keyword, "expected datatype %s, got %s",
+				describeValue(value), describeValue(dtype))...)
+		}
+	}
+	return errs
+}
+
+func (state *validation) checkObject(
+	node *yaml.Node, path []string, loc schemaLocation, keyword string, value *yaml.Node,
+	fail func(keyword, format string, args ...interface{}) ValidationErrors) ValidationErrors {
+	var errs ValidationErrors
+	switch keyword {
+	case "properties":
+		for i := 1; i < len(value.Content); i += 2 {
+			name := value.Content[i-1].Value
+			if child := mappingValue(node, name); child != nil {
+				errs = append(errs, state.check(child, appendPath(path, name),
+					loc.child(resolveAlias(value.Content[i]), keyword, name))...)
+			}
+		}
+	case "patternProperties":
+		for i := 1; i < len(value.Content); i += 2 {
+			re, err := regexp.Compile(value.Content[i-1].Value)
+			if err != nil {
+				errs = append(errs, fail(keyword, "invalid pattern: %v", err)...)
+				continue
+			}
+			for j := 1; j < len(node.Content); j += 2 {
+				name := node.Content[j-1].Value
+				if re.MatchString(name) {
+					errs = append(errs, state.check(node.Content[j], appendPath(path, name),
+						loc.child(resolveAlias(value.Content[i]), keyword, value.Content[i-1].Value))...)
+				}
+			}
+		}
+	case "additionalProperties":
+		properties := mappingValue(loc.node, "properties")
+		patterns := mappingValue(loc.node, "patternProperties")
+		for j := 1; j < len(node.Content); j += 2 {
+			name := node.Content[j-1].Value
+			if properties != nil && mappingValue(properties, name) != nil {
+				continue
+			}
+			matched := false
+			for i := 0; patterns != nil && i < len(patterns.Content) && !matched; i += 2 {
+				re, err := regexp.Compile(patterns.Content[i].Value)
+				matched = err == nil && re.MatchString(name)
+			}
+			if matched {
+				continue
+			}
+			if value.Kind == yaml.MappingNode {
+				errs = append(errs, state.check(node.Content[j], appendPath(path, name),
+					loc.child(value, keyword))...)
+			} else if !isTrue(value) {
+				errs = append(errs, fail(keyword, "unexpected property %s", name)...)
+			}
+		}
+	case "required":
+		for _, item := range value.Content {
+			if mappingValue(node, item.Value) == nil {
+				errs = append(errs, fail(keyword, "missing required property %s", item.Value)...)
+			}
+		}
+	case "dependencies":
+		for i := 1; i < len(value.Content); i += 2 {
+			name := value.Content[i-1].Value
+			if mappingValue(node, name) == nil {
+				continue
+			}
+			dependency := resolveAlias(value.Content[i])
+			if dependency.Kind == yaml.SequenceNode {
+				for _, item := range dependency.Content {
+					if mappingValue(node, item.Value) == nil {
+						errs = append(errs, fail(keyword, "property %s requires %s",
+							name, item.Value)...)
+					}
+				}
+			} else {
+				errs = append(errs, state.check(node, path, loc.child(dependency, keyword, name))...)
+			}
+		}
+	case "minProperties", "maxProperties":
+		limit, ok := numberValue(value)
+		count := float64(len(node.Content) / 2)
+		if ok && keyword == "minProperties" && count < limit {
+			errs = append(errs, fail(keyword, "expected at least %v properties, got %v",
+				limit, count)...)
+		} else if ok && keyword == "maxProperties" && count > limit {
+			errs = append(errs, fail(keyword, "expected at most %v properties, got %v",
+				limit, count)...)
+		}
+	}
+	return errs
+}
+
+func (state *validation) checkArray(
+	node *yaml.Node, path []string, loc schemaLocation, keyword string, value *yaml.Node,
+	fail func(keyword, format string, args ...interface{}) ValidationErrors) ValidationErrors {
+	var errs ValidationErrors
+	switch keyword {
+	case "items":
+		for i, item := range node.Content {
+			itemPath := appendPath(path, strconv.Itoa(i))
+			if value.Kind == yaml.MappingNode {
+				errs = append(errs, state.check(item, itemPath, loc.child(value, keyword))...)
+			} else if value.Kind == yaml.SequenceNode && i < len(value.Content) {
+				errs = append(errs, state.check(item, itemPath,
+					loc.child(resolveAlias(value.Content[i]), keyword, strconv.Itoa(i)))...)
+			}
+		}
+	case "additionalItems":
+		items := mappingValue(loc.node, "items")
+		if items == nil || items.Kind != yaml.SequenceNode {
+			break
+		}
+		for i := len(items.Content); i < len(node.Content); i++ {
+			if value.Kind == yaml.MappingNode {
+				errs = append(errs, state.check(node.Content[i], appendPath(path, strconv.Itoa(i)),
+					loc.child(value, keyword))...)
+			} else if !isTrue(value) {
+				errs = append(errs, fail(keyword, "expected at most %d items, got %d",
+					len(items.Content), len(node.Content))...)
+				break
+			}
+		}
+	case "minItems", "maxItems":
+		limit, ok := numberValue(value)
+		count := float64(len(node.Content))
+		if ok && keyword == "minItems" && count < limit {
+			errs = append(errs, fail(keyword, "expected at least %v items, got %v", limit, count)...)
+		} else if ok && keyword == "maxItems" && count > limit {
+			errs = append(errs, fail(keyword, "expected at most %v items, got %v", limit, count)...)
+		}
+	case "uniqueItems":
+		if !isTrue(value) {
+			break
+		}
+		for i := range node.Content {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(plainValue(node.Content[i]), plainValue(node.Content[j])) {
+					return append(errs, fail(keyword, "items %d and %d are equal", j, i)...)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// resolve finds the schema which `ref` points to relatively to `loc`.
+func (validator *Validator) resolve(loc schemaLocation, ref string) (schemaLocation, error) {
+	base, err := url.Parse(loc.id)
+	if err != nil {
+		return schemaLocation{}, err
+	}
+	relative, err := url.Parse(ref)
+	if err != nil {
+		return schemaLocation{}, errors.Wrapf(err, "invalid reference %s", ref)
+	}
+	target := base.ResolveReference(relative)
+	pointer := target.Fragment
+	target.Fragment = ""
+	id := target.String()
+	node, exists := validator.schemas[id]
+	if !exists {
+		return schemaLocation{}, errors.Errorf("unknown schema %s", id)
+	}
+	result := schemaLocation{id: id, node: node}
+	if pointer == "" {
+		return result, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return schemaLocation{}, errors.Errorf("unsupported fragment in %s", ref)
+	}
+	for _, key := range strings.Split(pointer[1:], "/") {
+		key = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+		var child *yaml.Node
+		switch result.node.Kind {
+		case yaml.MappingNode:
+			child = mappingValue(result.node, key)
+		case yaml.SequenceNode:
+			if index, err := strconv.Atoi(key); err == nil && index >= 0 &&
+				index < len(result.node.Content) {
+				child = result.node.Content[index]
+			}
+		}
+		if child == nil {
+			return schemaLocation{}, errors.Errorf("%s does not exist", ref)
+		}
+		result = result.child(resolveAlias(child), key)
+	}
+	return result, nil
+}
+
+func appendPath(path []string, key string) []string {
+	return append(path[:len(path):len(path)], key)
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// mappingValue returns the value of `key` in the mapping node, or nil if it does not exist.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 1; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i-1].Value == key {
+			return resolveAlias(mapping.Content[i])
+		}
+	}
+	return nil
+}
+
+func isTrue(node *yaml.Node) bool {
+	return node != nil && plainValue(node) == true
+}
+
+// untagged returns the copy of the scalar node without the application specific tag,
+// so that the YAML parser infers the standard one.
+func untagged(node *yaml.Node) *yaml.Node {
+	plain := *node
+	if !strings.HasPrefix(plain.Tag, "!!") {
+		plain.Tag = ""
+	}
+	return &plain
+}
+
+// nodeType returns the JSON type of the node.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch untagged(node).ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+func matchesType(node *yaml.Node, types *yaml.Node) bool {
+	actual := nodeType(node)
+	names := []*yaml.Node{types}
+	if types.Kind == yaml.SequenceNode {
+		names = types.Content
+	}
+	for _, name := range names {
+		if name.Value == actual || (name.Value == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// plainValue converts the node to an untagged Go value. The numbers become float64.
+func plainValue(node *yaml.Node) interface{} {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			list[i] = plainValue(item)
+		}
+		return list
+	case yaml.MappingNode:
+		object := map[string]interface{}{}
+		for i := 1; i < len(node.Content); i += 2 {
+			object[node.Content[i-1].Value] = plainValue(node.Content[i])
+		}
+		return object
+	}
+	if number, ok := numberValue(node); ok {
+		return number
+	}
+	var value interface{}
+	if err := untagged(node).Decode(&value); err != nil {
+		return node.Value
+	}
+	return value
+}
+
+func numberValue(node *yaml.Node) (float64, bool) {
+	if node.Kind != yaml.ScalarNode {
+		return 0, false
+	}
+	if kind := nodeType(node); kind != "integer" && kind != "number" {
+		return 0, false
+	}
+	var number float64
+	if err := untagged(node).Decode(&number); err != nil {
+		return 0, false
+	}
+	return number, true
+}
+
+func describeValue(node *yaml.Node) string {
+	if node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	return fmt.Sprint(plainValue(node))
+}
+
+// matchesTag checks the tag against the pattern which may end with "*", e.g.
+// "tag:stsci.edu:asdf/core/ndarray-1.*".
+func matchesTag(tag, pattern string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(tag, pattern[:len(pattern)-1])
+	}
+	return tag == pattern
+}
+
+// arrayDimensions returns the number of dimensions of the core/ndarray node. The node is either
+// a mapping with `shape` or `data`, or the inline data itself.
+func arrayDimensions(node *yaml.Node) (int, bool) {
+	if node.Kind == yaml.MappingNode {
+		if shape := mappingValue(node, "shape"); shape != nil && shape.Kind == yaml.SequenceNode {
+			return len(shape.Content), true
+		}
+		node = mappingValue(node, "data")
+		if node == nil {
+			return 0, false
+		}
+	}
+	if node.Kind != yaml.SequenceNode {
+		return 0, false
+	}
+	ndim := 0
+	for node.Kind == yaml.SequenceNode {
+		ndim++
+		if len(node.Content) == 0 {
+			break
+		}
+		node = resolveAlias(node.Content[0])
+	}
+	return ndim, true
+}
+
+// canCastDataType checks whether the values of the scalar ASDF data type `from` can be
+// converted to `to` without the loss of precision.
+func canCastDataType(from, to string) bool {
+	parse := func(dtype string) (string, int) {
+		kind := strings.TrimRight(dtype, "0123456789")
+		bits, err := strconv.Atoi(dtype[len(kind):])
+		if err != nil {
+			return "", 0
+		}
+		return kind, bits
+	}
+	fromKind, fromBits := parse(from)
+	toKind, toBits := parse(to)
+	if fromKind == "" || toKind == "" {
+		return false
+	}
+	if toKind == "complex" {
+		// both the real and the imaginary parts
+		toBits /= 2
+	}
+	switch fromKind {
+	case "bool":
+		return true
+	case "uint", "int":
+		if toKind == fromKind {
+			return toBits >= fromBits
+		}
+		return (toKind == "int" || toKind == "float" || toKind == "complex") && toBits > fromBits
+	case "float":
+		return (toKind == "float" || toKind == "complex") && toBits >= fromBits
+	case "complex":
+		return toKind == "complex" && toBits*2 >= fromBits
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const testArraySchema = `
+id: "http://example.com/schemas/array-1.0.0"
+tag: "tag:example.com:array-1.0.0"
+type: object
+`
+
+const testImageSchema = `
+id: "http://example.com/schemas/image-1.0.0"
+tag: "tag:example.com:image-1.0.0"
+type: object
+properties:
+  pixels:
+    tag: "tag:example.com:array-1.*"
+    ndim: 2
+    datatype: float32
+  channels:
+    type: array
+    items:
+      $ref: "#/definitions/channel"
+    minItems: 1
+  thumbnail:
+    anyOf:
+      - type: "null"
+      - allOf:
+          - $ref: "array-1.0.0"
+          - max_ndim: 2
+definitions:
+  channel:
+    type: object
+    properties:
+      name: {type: string, pattern: "^[a-z]+$"}
+      data:
+        $ref: "#/definitions/data"
+    required: [name]
+    additionalProperties: false
+  data:
+    tag: "tag:example.com:array-1.0.0"
+    max_ndim: 1
+    datatype: uint8
+    exact_datatype: true
+`
+
+func newTestValidator(req *require.Assertions) *Validator {
+	validator := NewValidator()
+	req.NoError(validator.AddSchema([]byte(testArraySchema)))
+	req.NoError(validator.AddSchema([]byte(testImageSchema)))
+	return validator
+}
+
+func validateYAML(req *require.Assertions, validator *Validator, source string) ValidationErrors {
+	var doc yaml.Node
+	req.NoError(yaml.Unmarshal([]byte(source), &doc))
+	err := validator.Validate(&doc)
+	if err == nil {
+		return nil
+	}
+	return err.(ValidationErrors)
+}
+
+func TestValidatorValid(t *testing.T) {
+	req := require.New(t)
+	validator := newTestValidator(req)
+	req.Nil(validateYAML(req, validator, `
+%TAG !ex! tag:example.com:
+---
+image: !ex!image-1.0.0
+  pixels: !ex!array-1.2.0 {shape: [4, 4], datatype: int16}
+  channels:
+  - {name: red, data: !ex!array-1.0.0 {shape: [4], datatype: uint8}}
+  - {name: green}
+  thumbnail: null
+`))
+	req.Nil(validateYAML(req, validator, `
+image: !<tag:example.com:image-1.0.0>
+  pixels: !<tag:example.com:array-1.0.0> {data: [[1, 2], [3, 4]], datatype: float32}
+  channels: [{name: blue}]
+  thumbnail: !<tag:example.com:array-1.0.0> {shape: [2, 2]}
+`))
+}
+
+func TestValidatorErrors(t *testing.T) {
+	req := require.New(t)
+	validator := newTestValidator(req)
+	errs := validateYAML(req, validator, `
+%TAG !ex! tag:example.com:
+---
+images:
+- !ex!image-1.0.0
+  pixels: !ex!array-1.0.0 {shape: [4, 4, 3], datatype: float64}
+  channels:
+  - {name: Red, data: !ex!array-1.0.0 {shape: [4], datatype: uint8}}
+  - {name: green, data: {shape: [4], datatype: int8}, extra: 1}
+  thumbnail: !ex!array-1.0.0 {shape: [4, 4, 3]}
+- !ex!image-1.0.0
+  pixels: !ex!array-1.0.0 {shape: [4, 4], datatype: float32}
+  channels: []
+`)
+	base := "http://example.com/schemas/image-1.0.0#"
+	req.Equal(ValidationErrors{
+		{"images.0.pixels", base + "/properties/pixels/ndim", "expected 2 dimensions, got 3"},
+		{"images.0.pixels", base + "/properties/pixels/datatype",
+			"expected datatype float32, got float64"},
+		{"images.0.channels.0.name", base + "/definitions/channel/properties/name/pattern",
+			`"Red" does not match ^[a-z]+$`},
+		{"images.0.channels.1.data", base + "/definitions/data/tag",
+			"expected tag tag:example.com:array-1.0.0, got no tag"},
+		{"images.0.channels.1.data", base + "/definitions/data/datatype",
+			"expected datatype uint8, got int8"},
+		{"images.0.channels.1", base + "/definitions/channel/additionalProperties",
+			"unexpected property extra"},
+		{"images.0.thumbnail", base + "/properties/thumbnail/anyOf",
+			"does not match any of the 2 alternatives"},
+		{"images.1.channels", base + "/properties/channels/minItems",
+			"expected at least 1 items, got 0"},
+	}, errs)
+	req.Contains(errs.Error(), "8 validation error(s)")
+	req.Contains(errs[0].Error(), "images.0.pixels: expected 2 dimensions, got 3 (")
+}
+
+func TestValidatorCastDataType(t *testing.T) {
+	req := require.New(t)
+	validator := newTestValidator(req)
+	for dtype, valid := range map[string]bool{
+		"float32": true, "float64": false, "uint8": true, "int16": true, "int32": false,
+		"uint32": false, "bool8": true, "complex64": false, "[ascii, 8]": false,
+	} {
+		errs := validateYAML(req, validator, `
+image: !<tag:example.com:image-1.0.0>
+  pixels: !<tag:example.com:array-1.0.0> {shape: [4, 4], datatype: `+dtype+`}
+`)
+		req.Equal(valid, errs == nil, dtype)
+	}
+}
+
+func TestValidatorUnresolvedRef(t *testing.T) {
+	req := require.New(t)
+	validator := NewValidator()
+	req.NoError(validator.AddSchema([]byte(`
+id: "http://example.com/schemas/remote-1.0.0"
+tag: "tag:example.com:remote-1.0.0"
+properties:
+  a: {$ref: "http://example.org/schemas/other-1.0.0"}
+  b: {$ref: "#/definitions/missing"}
+  c: {$ref: "#/definitions/loop"}
+definitions:
+  loop: {$ref: "#/definitions/loop"}
+`)))
+	errs := validateYAML(req, validator, "x: !<tag:example.com:remote-1.0.0> {a: 1, b: 2, c: 3}")
+	req.Len(errs, 3)
+	req.Equal("x.a", errs[0].Path)
+	req.Equal("unknown schema http://example.org/schemas/other-1.0.0", errs[0].Message)
+	req.Equal("http://example.com/schemas/remote-1.0.0#/properties/a/$ref", errs[0].Rule)
+	req.Equal("#/definitions/missing does not exist", errs[1].Message)
+	req.Equal("too deeply nested references", errs[2].Message)
+}
+
+func TestValidatorAddSchema(t *testing.T) {
+	req := require.New(t)
+	validator := newTestValidator(req)
+	req.Error(validator.AddSchema([]byte(testArraySchema)))
+	req.Error(validator.AddSchema([]byte("type: object")))
+	req.Error(validator.AddSchema([]byte("id: relative-1.0.0")))
+	req.Error(validator.AddSchema([]byte("[1, 2]")))
+	req.Error(validator.AddSchema([]byte(`
+id: "http://example.com/schemas/array-2.0.0"
+tag: "tag:example.com:array-1.0.0"
+`)))
+	req.Panics(func() { validator.MustAddSchema([]byte(testImageSchema)) })
+}
+
+func TestValidatorCompatibleTags(t *testing.T) {
+	req := require.New(t)
+	validator := newTestValidator(req)
+	for _, tag := range []string{"1.0.0", "1.0.5", "1.3.0"} {
+		errs := validateYAML(req, validator,
+			"!<tag:example.com:array-"+tag+"> [1, 2]")
+		req.Len(errs, 1, tag)
+		req.Equal("http://example.com/schemas/array-1.0.0#/type", errs[0].Rule, tag)
+	}
+	req.Empty(validateYAML(req, validator, "!<tag:example.com:array-2.0.0> [1, 2]"))
+	req.Empty(validateYAML(req, validator, "!<tag:example.org:array-1.0.0> [1, 2]"))
+	req.Error(validator.SetTagSchema("tag:example.org:array-1.0.0", "http://example.com/missing"))
+	req.NoError(validator.SetTagSchema("tag:example.org:array-1.0.0",
+		"http://example.com/schemas/array-1.0.0"))
+	req.Len(validateYAML(req, validator, "!<tag:example.org:array-1.1.0> [1, 2]"), 1)
+	// the previous declaration is replaced
+	req.NoError(validator.AddSchema([]byte(`
+id: "http://example.com/schemas/array-1.0.0-relaxed"
+anyOf:
+  - $ref: "array-1.0.0"
+  - type: array
+`)))
+	validator.MustSetTagSchema("tag:example.com:array-1.0.0",
+		"http://example.com/schemas/array-1.0.0-relaxed")
+	req.Empty(validateYAML(req, validator, "!<tag:example.com:array-1.0.0> [1, 2]"))
+	req.Panics(func() {
+		validator.MustSetTagSchema("tag:example.com:array-1.0.0", "http://example.com/missing")
+	})
+}
@@ -1,9 +1,16 @@
 package asdf
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/go-asdf/schema/core"
 )
 
 func TestStandardAscii(t *testing.T) {
@@ -11,6 +18,27 @@ func TestStandardAscii(t *testing.T) {
 	asdfFile, err := OpenFile("testdata/standard/ascii.asdf", nil)
 	req.NoError(err)
 	req.NotNil(asdfFile)
+	req.Empty(asdfFile.Diagnostics)
+	for _, file := range []*File{asdfFile, writeInline(t, asdfFile)} {
+		arr := file.Tree.Path("data").Data().(*core.NDArray)
+		req.Equal(core.ASCII, arr.DataType)
+		req.Equal(5, arr.StringLength)
+		strs, err := arr.Strings()
+		req.NoError(err)
+		req.Equal([]string{"", "ascii"}, strs)
+	}
+	arr := asdfFile.Tree.Path("data").Data().(*core.NDArray)
+	req.NoError(arr.Set("asdf", 0))
+	req.Error(arr.Set("toolong", 0))
+	req.Error(arr.Set("\u00e6", 0))
+	value, err := arr.At(0)
+	req.NoError(err)
+	req.Equal("asdf", value)
+	var decoded struct {
+		Data []string `asdf:"data"`
+	}
+	req.NoError(asdfFile.Decode(&decoded))
+	req.Equal([]string{"asdf", "ascii"}, decoded.Data)
 }
 
 func TestStandardBasic(t *testing.T) {
@@ -37,8 +65,48 @@ func TestStandardCompressed(t *testing.T) {
 func TestStandardExploded(t *testing.T) {
 	req := require.New(t)
 	asdfFile, err := OpenFile("testdata/standard/exploded.asdf", nil)
+	req.NoError(err)
+	req.NotNil(asdfFile)
+	arr := asdfFile.Tree.Path("data").Data().(*core.NDArray)
+	req.Equal("exploded0000.asdf", arr.SourceURI())
+	req.Equal(-1, arr.Source())
+	req.Len(arr.Data, 64)
+	for i := 0; i < 8; i++ {
+		req.Equal(uint64(i), binary.LittleEndian.Uint64(arr.Data[i*8:]))
+	}
+}
+
+func TestStandardExplodedResolver(t *testing.T) {
+	req := require.New(t)
+	contents, err := ioutil.ReadFile("testdata/standard/exploded.asdf")
+	req.NoError(err)
+	_, err = Open(bytes.NewReader(contents), nil)
+	req.Error(err)
+	var resolved []string
+	asdfFile, err := OpenWithOptions(bytes.NewReader(contents), OpenOptions{
+		Lazy: true,
+		Resolver: func(uri string) (io.ReadSeeker, error) {
+			resolved = append(resolved, uri)
+			return DirectoryResolver("testdata/standard")(uri)
+		},
+	})
+	req.NoError(err)
+	req.Empty(resolved)
+	arr := asdfFile.Tree.Path("data").Data().(*core.NDArray)
+	req.NoError(arr.Load())
+	req.Equal([]string{"exploded0000.asdf"}, resolved)
+	req.Len(arr.Data, 64)
+	_, err = DirectoryResolver("testdata/standard")("http://example.com/exploded0000.asdf")
 	req.Error(err)
-	req.Nil(asdfFile)
+	reader, err := DirectoryResolver("testdata")("standard/../standard/exploded0000.asdf")
+	req.NoError(err)
+	req.NoError(reader.(io.Closer).Close())
+	for _, uri := range []string{
+		"/etc/passwd", "file:///etc/passwd", "../default.asdf", "sub/../../default.asdf", "..",
+	} {
+		_, err = DirectoryResolver("testdata/standard")(uri)
+		req.Error(err, uri)
+	}
 }
 
 func TestStandardFloat(t *testing.T) {
@@ -46,6 +114,31 @@ func TestStandardFloat(t *testing.T) {
 	asdfFile, err := OpenFile("testdata/standard/float.asdf", nil)
 	req.NoError(err)
 	req.NotNil(asdfFile)
+	float32s := []float32{0, float32(math.Copysign(0, -1)), float32(math.NaN()),
+		float32(math.Inf(1)), float32(math.Inf(-1)), -math.MaxFloat32, math.MaxFloat32,
+		1.1920929e-07, 5.9604645e-08, 1.1754944e-38}
+	float64s := []float64{0, math.Copysign(0, -1), math.NaN(), math.Inf(1), math.Inf(-1),
+		-math.MaxFloat64, math.MaxFloat64, 2.220446049250313e-16, 1.1102230246251565e-16,
+		2.2250738585072014e-308}
+	checkFloats := func(file *File) {
+		for _, order := range []string{"<", ">"} {
+			f4, err := file.Tree.Path("datatype" + order + "f4").Data().(*core.NDArray).Float32s()
+			req.NoError(err)
+			f8, err := file.Tree.Path("datatype" + order + "f8").Data().(*core.NDArray).Float64s()
+			req.NoError(err)
+			for i := range float32s {
+				if math.IsNaN(float64(float32s[i])) {
+					req.True(math.IsNaN(float64(f4[i])))
+					req.True(math.IsNaN(f8[i]))
+					continue
+				}
+				req.Equal(math.Float32bits(float32s[i]), math.Float32bits(f4[i]), "f4 %d", i)
+				req.Equal(math.Float64bits(float64s[i]), math.Float64bits(f8[i]), "f8 %d", i)
+			}
+		}
+	}
+	checkFloats(asdfFile)
+	checkFloats(writeInline(t, asdfFile))
 }
 
 func TestStandardInt(t *testing.T) {
@@ -53,13 +146,74 @@ func TestStandardInt(t *testing.T) {
 	asdfFile, err := OpenFile("testdata/standard/int.asdf", nil)
 	req.NoError(err)
 	req.NotNil(asdfFile)
+	checkInts := func(file *File) {
+		for _, order := range []string{"<", ">"} {
+			arr := func(name string) *core.NDArray {
+				return file.Tree.Path("datatype" + order + name).Data().(*core.NDArray)
+			}
+			i1, err := arr("i1").Int8s()
+			req.NoError(err)
+			req.Equal([]int8{math.MaxInt8, math.MinInt8, 0}, i1)
+			i2, err := arr("i2").Int16s()
+			req.NoError(err)
+			req.Equal([]int16{math.MaxInt16, math.MinInt16, 0}, i2)
+			i4, err := arr("i4").Int32s()
+			req.NoError(err)
+			req.Equal([]int32{math.MaxInt32, math.MinInt32, 0}, i4)
+			u1, err := arr("u1").Uint8s()
+			req.NoError(err)
+			req.Equal([]uint8{math.MaxUint8, 0}, u1)
+			u2, err := arr("u2").Uint16s()
+			req.NoError(err)
+			req.Equal([]uint16{math.MaxUint16, 0}, u2)
+			u4, err := arr("u4").Uint32s()
+			req.NoError(err)
+			req.Equal([]uint32{math.MaxUint32, 0}, u4)
+		}
+	}
+	checkInts(asdfFile)
+	checkInts(writeInline(t, asdfFile))
 }
 
-func TestStandardShared(t *testing.T) {
+// writeInline writes all the arrays in the tree inline and opens the result.
+func writeInline(t *testing.T, file *File) *File {
 	req := require.New(t)
-	asdfFile, err := OpenFile("testdata/standard/shared.asdf", nil)
+	for _, val := range file.Tree.ChildrenMap() {
+		if arr, ok := val.Data().(*core.NDArray); ok {
+			arr.Inline = true
+		}
+	}
+	buffer := &bytes.Buffer{}
+	_, err := file.WriteTo(buffer)
 	req.NoError(err)
-	req.NotNil(asdfFile)
+	req.NotContains(buffer.String(), "BLK")
+	inline, err := Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	return inline
+}
+
+func TestStandardShared(t *testing.T) {
+	req := require.New(t)
+	int64s := func(arr *core.NDArray) []int64 {
+		values := make([]int64, arr.CountElements())
+		for i := range values {
+			values[i] = int64(arr.ByteOrder.Uint64(arr.Data[i*8:]))
+		}
+		return values
+	}
+	for _, lazy := range []bool{false, true} {
+		asdfFile, err := OpenFileWithOptions("testdata/standard/shared.asdf", OpenOptions{Lazy: lazy})
+		req.NoError(err)
+		data := asdfFile.Tree.Path("data").Data().(*core.NDArray)
+		req.NoError(data.Load())
+		req.Equal([]int{8}, data.Shape)
+		req.Equal([]int64{0, 1, 2, 3, 4, 5, 6, 7}, int64s(data))
+		subset := asdfFile.Tree.Path("subset").Data().(*core.NDArray)
+		req.NoError(subset.Load())
+		req.Equal([]int{4}, subset.Shape)
+		req.Equal([]int64{1, 3, 5, 7}, int64s(subset))
+		req.NoError(asdfFile.Close())
+	}
 }
 
 func TestStandardUnicodeBmp(t *testing.T) {
@@ -67,6 +221,7 @@ func TestStandardUnicodeBmp(t *testing.T) {
 	asdfFile, err := OpenFile("testdata/standard/unicode_bmp.asdf", nil)
 	req.NoError(err)
 	req.NotNil(asdfFile)
+	checkUnicode(t, asdfFile, "\u00c6\u02a9")
 }
 
 func TestStandardUnicodeSpp(t *testing.T) {
@@ -74,4 +229,37 @@ func TestStandardUnicodeSpp(t *testing.T) {
 	asdfFile, err := OpenFile("testdata/standard/unicode_spp.asdf", nil)
 	req.NoError(err)
 	req.NotNil(asdfFile)
+	checkUnicode(t, asdfFile, "\U00010020")
+}
+
+// checkUnicode tests the UCS4 arrays in both byte orders, written to blocks and inline.
+func checkUnicode(t *testing.T, asdfFile *File, expected string) {
+	req := require.New(t)
+	req.Empty(asdfFile.Diagnostics)
+	buffer := &bytes.Buffer{}
+	_, err := asdfFile.WriteTo(buffer)
+	req.NoError(err)
+	rewritten, err := Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	for _, file := range []*File{asdfFile, rewritten, writeInline(t, asdfFile)} {
+		for _, name := range []string{"datatype<U", "datatype>U"} {
+			arr := file.Tree.Path(name).Data().(*core.NDArray)
+			req.Equal(core.UCS4, arr.DataType)
+			req.Equal(2, arr.StringLength)
+			req.Equal(8, arr.ElementSize())
+			strs, err := arr.Strings()
+			req.NoError(err)
+			req.Equal([]string{"", expected}, strs)
+			arr.EnsureHostEndianness()
+			strs, err = arr.Strings()
+			req.NoError(err)
+			req.Equal([]string{"", expected}, strs)
+		}
+	}
+	arr := asdfFile.Tree.Path("datatype>U").Data().(*core.NDArray)
+	req.NoError(arr.Set("\u00e6\U0001f600", 0))
+	req.Error(arr.Set("abc", 0))
+	value, err := arr.At(0)
+	req.NoError(err)
+	req.Equal("\u00e6\U0001f600", value)
 }
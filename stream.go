@@ -0,0 +1,109 @@
+package asdf
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+// StreamWriter appends rows to the streamed array of an ASDF file, e.g. to log data continuously.
+// The file is valid at any moment: the readers see all the complete rows written so far.
+type StreamWriter struct {
+	file    *os.File
+	rowSize int
+	// written is the number of bytes appended to the streamed block.
+	written int64
+}
+
+// CreateStream writes the file with the streamed array to the file system and returns the writer
+// which appends more rows to that array. The tree must contain exactly one array with
+// a negative first dimension, e.g. `Shape: []int{-1, height, width}`; its `Data` becomes
+// the initial rows. The file is overwritten if it exists.
+func CreateStream(fileName string, file *File) (*StreamWriter, error) {
+	var stream *core.NDArray
+	streams := 0
+	file.IterArrays(func(arr *core.NDArray) {
+		if len(arr.Shape) > 0 && arr.Shape[0] < 0 {
+			stream = arr
+			streams++
+		}
+	})
+	if streams != 1 {
+		return nil, errors.Errorf("the tree must contain exactly one streamed array, got %d", streams)
+	}
+	rowSize := stream.ElementSize()
+	for _, dim := range stream.Shape[1:] {
+		rowSize *= dim
+	}
+	if rowSize == 0 {
+		return nil, errors.Errorf("the streamed array has empty rows: %s", stream.String())
+	}
+	output, err := os.Create(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", fileName)
+	}
+	if _, err = file.WriteTo(output); err == nil {
+		err = output.Sync()
+	}
+	if err != nil {
+		output.Close()
+		return nil, errors.Wrapf(err, "failed to write %s", fileName)
+	}
+	return &StreamWriter{file: output, rowSize: rowSize, written: int64(len(stream.Data))}, nil
+}
+
+// Write appends the raw data to the streamed array. The data must be in the array's byte order.
+// A row may be split between several calls, the readers ignore the incomplete trailing row.
+func (sw *StreamWriter) Write(data []byte) (int, error) {
+	if sw.file == nil {
+		return 0, errors.New("the stream is closed")
+	}
+	n, err := sw.file.Write(data)
+	sw.written += int64(n)
+	return n, err
+}
+
+// Rows returns the number of complete rows in the streamed array.
+func (sw *StreamWriter) Rows() int {
+	return int(sw.written / int64(sw.rowSize))
+}
+
+// Sync commits the written rows to stable storage.
+func (sw *StreamWriter) Sync() error {
+	if sw.file == nil {
+		return errors.New("the stream is closed")
+	}
+	return sw.file.Sync()
+}
+
+// Close finalizes the file. The incomplete trailing row is truncated, and an error is returned
+// in that case.
+func (sw *StreamWriter) Close() error {
+	if sw.file == nil {
+		return errors.New("the stream is closed")
+	}
+	var err error
+	if tail := sw.written % int64(sw.rowSize); tail != 0 {
+		size, seekErr := sw.file.Seek(0, io.SeekEnd)
+		if seekErr == nil {
+			seekErr = sw.file.Truncate(size - tail)
+		}
+		if seekErr != nil {
+			err = errors.Wrap(seekErr, "failed to truncate the incomplete row")
+		} else {
+			err = errors.Errorf("truncated the incomplete row of %d bytes", tail)
+			sw.written -= tail
+		}
+	}
+	if syncErr := sw.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := sw.file.Close(); err == nil {
+		err = closeErr
+	}
+	sw.file = nil
+	return err
+}
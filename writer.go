@@ -0,0 +1,192 @@
+package asdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/src-d/go-asdf/schema"
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+var (
+	// WrittenFormatVersion is the version of the file format produced by `File.WriteTo`.
+	WrittenFormatVersion = semver.MustParse("1.0.0")
+	// WrittenStandardVersion is the version of the ASDF standard followed by `File.WriteTo`.
+	WrittenStandardVersion = semver.MustParse("1.3.0")
+	// Library is written to `asdf_library` if the document does not specify it.
+	Library = core.Software{
+		Tag:      schema.Tag{Name: "go-asdf", Version: semver.MustParse("0.1.0")},
+		Author:   "source{d}",
+		HomePage: "https://github.com/src-d/go-asdf",
+	}
+)
+
+// stsciTagPrefix is mapped to the primary tag handle "!".
+const stsciTagPrefix = "tag:stsci.edu:asdf/"
+
+// Create writes ASDF to the file system. The file is overwritten if it exists.
+func Create(fileName string, file *File) error {
+	output, err := os.Create(fileName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", fileName)
+	}
+	buffer := bufio.NewWriter(output)
+	_, err = file.WriteTo(buffer)
+	if err == nil {
+		err = buffer.Flush()
+	}
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", fileName)
+	}
+	return nil
+}
+
+// WriteTo serializes the file in ASDF format: the header, the YAML tree, the binary blocks
+// and the block index. Each array in the tree is written to a separate block unless several
+// arrays share the same `Data`. If there is a streamed array (the first dimension is negative),
+// its block goes last and the block index is not written. `FormatVersion` and
+// `StandardVersion` are ignored, the written versions are always `WrittenFormatVersion` and
+// `WrittenStandardVersion`.
+func (file *File) WriteTo(writer io.Writer) (int64, error) {
+	blocks := &blockList{}
+	doc := file.Document
+	if doc.Library == nil && (doc.Tree == nil || !doc.Tree.Exists("asdf_library")) {
+		lib := Library
+		doc.Library = &lib
+	}
+	tree, err := doc.MarshalASDF(blocks)
+	if err != nil {
+		return 0, err
+	}
+	if blocks.HasStreamed && blocks.StreamedIndex != len(blocks.Data) {
+		// the streamed block must be the last, so we need to know the number of the others
+		blocks = &blockList{StreamedIndex: len(blocks.Data)}
+		if tree, err = doc.MarshalASDF(blocks); err != nil {
+			return 0, err
+		}
+	}
+	tree = shortenTags(tree)
+	output := &countingWriter{Writer: writer}
+	_, err = fmt.Fprintf(output, "#ASDF %s\n#ASDF_STANDARD %s\n%%YAML 1.1\n%%TAG ! %s\n--- ",
+		WrittenFormatVersion, WrittenStandardVersion, stsciTagPrefix)
+	if err != nil {
+		return output.Written, err
+	}
+	encoder := yaml.NewEncoder(output)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(tree); err != nil {
+		return output.Written, errors.Wrap(err, "failed to encode YAML")
+	}
+	if err = encoder.Close(); err != nil {
+		return output.Written, errors.Wrap(err, "failed to encode YAML")
+	}
+	if _, err = output.Write([]byte("...\n")); err != nil {
+		return output.Written, err
+	}
+	offsets := make([]string, 0, len(blocks.Data))
+	for i, data := range blocks.Data {
+		offsets = append(offsets, strconv.FormatInt(output.Written, 10))
+		if err = WriteBlock(output, &Block{Data: data}); err != nil {
+			return output.Written, errors.Wrapf(err, "writing block #%d", i)
+		}
+	}
+	if blocks.HasStreamed {
+		err = WriteBlock(output, &Block{Data: blocks.Streamed, Flags: FlagStreamed})
+		if err != nil {
+			err = errors.Wrapf(err, "writing block #%d", blocks.StreamedIndex)
+		}
+	} else if len(offsets) > 0 {
+		_, err = fmt.Fprintf(output, "%s\n%%YAML 1.1\n--- [%s]\n...\n",
+			blockIndexHeader, strings.Join(offsets, ", "))
+	}
+	return output.Written, err
+}
+
+// blockIndexHeader starts the optional YAML document with the block offsets at the end of the file.
+const blockIndexHeader = "#ASDF BLOCK INDEX"
+
+// blockList implements `schema.BlockAppender`.
+type blockList struct {
+	Data [][]byte
+	// Streamed is the payload of the streamed block.
+	Streamed []byte
+	// HasStreamed indicates whether there is a streamed block.
+	HasStreamed bool
+	// StreamedIndex is the index assigned to the streamed block.
+	StreamedIndex int
+}
+
+// AppendBlock schedules writing another block. Arrays which share the same memory share the block.
+func (bl *blockList) AppendBlock(data []byte) int {
+	for i, existing := range bl.Data {
+		if len(existing) == len(data) && (len(data) == 0 || &existing[0] == &data[0]) {
+			return i
+		}
+	}
+	bl.Data = append(bl.Data, data)
+	return len(bl.Data) - 1
+}
+
+// AppendStreamedBlock schedules writing the streamed block.
+func (bl *blockList) AppendStreamedBlock(data []byte) (int, error) {
+	if bl.HasStreamed {
+		return -1, errors.New("there can be only one streamed array")
+	}
+	bl.Streamed = data
+	bl.HasStreamed = true
+	return bl.StreamedIndex, nil
+}
+
+// shortenTags replaces the standard tag prefix with the "!" handle declared in the header.
+// The nodes are copied because they may belong to the tree, e.g. to TaggedValue or `Extra`.
+func shortenTags(node *yaml.Node) *yaml.Node {
+	return copyShortened(node, map[*yaml.Node]*yaml.Node{})
+}
+
+// copyShortened deeply copies the node with the shortened tags. `copies` preserve the shared
+// nodes and the aliases.
+func copyShortened(node *yaml.Node, copies map[*yaml.Node]*yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if copied, exists := copies[node]; exists {
+		return copied
+	}
+	copied := *node
+	copies[node] = &copied
+	if strings.HasPrefix(copied.Tag, stsciTagPrefix) {
+		copied.Tag = "!" + copied.Tag[len(stsciTagPrefix):]
+	}
+	if node.Content != nil {
+		copied.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			copied.Content[i] = copyShortened(child, copies)
+		}
+	}
+	copied.Alias = copyShortened(node.Alias, copies)
+	return &copied
+}
+
+// countingWriter tracks the number of bytes written so far.
+type countingWriter struct {
+	io.Writer
+	Written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.Written += int64(n)
+	return n, err
+}
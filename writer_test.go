@@ -0,0 +1,241 @@
+package asdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/src-d/go-asdf/schema"
+	"github.com/src-d/go-asdf/schema/core"
+)
+
+func TestWriteToRoundTrip(t *testing.T) {
+	req := require.New(t)
+	arr := &core.NDArray{
+		DataType:  types.Typ[types.Int32],
+		Shape:     []int{2, 3},
+		ByteOrder: binary.BigEndian,
+		Data:      make([]byte, 24),
+	}
+	for i := 0; i < 6; i++ {
+		binary.BigEndian.PutUint32(arr.Data[i*4:], uint32(i*10))
+	}
+	tree := gabs.New()
+	_, err := tree.Set(arr, "one", "arr")
+	req.NoError(err)
+	_, err = tree.Set(arr, "shared")
+	req.NoError(err)
+	_, err = tree.Set("1.5", "str")
+	req.NoError(err)
+	_, err = tree.Set(1.0, "float")
+	req.NoError(err)
+	_, err = tree.Set([]interface{}{1, 2, 3}, "list")
+	req.NoError(err)
+	file := &File{Document: core.Document{Tree: tree}}
+	buffer := &bytes.Buffer{}
+	n, err := file.WriteTo(buffer)
+	req.NoError(err)
+	req.Equal(int64(buffer.Len()), n)
+	req.True(strings.HasPrefix(buffer.String(),
+		"#ASDF 1.0.0\n#ASDF_STANDARD 1.3.0\n%YAML 1.1\n%TAG ! tag:stsci.edu:asdf/\n--- !core/asdf-1.1.0\n"))
+	req.Equal(2, strings.Count(buffer.String(), "source: 0\n"))
+	req.Equal(1, bytes.Count(buffer.Bytes(), blockMagic[:]))
+	req.True(strings.HasSuffix(buffer.String(), fmt.Sprintf(
+		"#ASDF BLOCK INDEX\n%%YAML 1.1\n--- [%d]\n...\n", bytes.Index(buffer.Bytes(), blockMagic[:]))))
+
+	loaded, err := Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	req.Equal(Library, *loaded.Library)
+	req.Equal("1.5", loaded.Tree.Path("str").Data())
+	req.Equal(1.0, loaded.Tree.Path("float").Data())
+	req.Len(loaded.Tree.Path("list").Children(), 3)
+	for _, path := range []string{"one.arr", "shared"} {
+		loadedArr := loaded.Tree.Path(path).Data().(*core.NDArray)
+		req.Equal(arr.Shape, loadedArr.Shape)
+		req.Equal(arr.DataType, loadedArr.DataType)
+		req.Equal(binary.BigEndian, loadedArr.ByteOrder)
+		req.Equal(arr.Data, loadedArr.Data)
+	}
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	req := require.New(t)
+	original, err := OpenFile("testdata/default.asdf", nil)
+	req.NoError(err)
+	tmpdir, err := ioutil.TempDir("", "go-asdf-")
+	req.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	fileName := filepath.Join(tmpdir, "default.asdf")
+	req.NoError(Create(fileName, original))
+	copied, err := OpenFile(fileName, nil)
+	req.NoError(err)
+	req.Equal(original.Library, copied.Library)
+	req.Equal(original.History, copied.History)
+	req.Equal(original.Tree.Path("one.two").Data(), copied.Tree.Path("one.two").Data())
+	req.Equal(original.Tree.Path("one.three").Data(), copied.Tree.Path("one.three").Data())
+	for _, path := range []string{"arrs.0", "arrs.1", "arrs.2", "one.four.five"} {
+		originalArr := original.Tree.Path(path).Data().(*core.NDArray)
+		copiedArr := copied.Tree.Path(path).Data().(*core.NDArray)
+		req.Equal(originalArr.String(), copiedArr.String())
+		req.Equal(originalArr.Data, copiedArr.Data)
+	}
+}
+
+func TestWriteToLazy(t *testing.T) {
+	req := require.New(t)
+	original, err := OpenFile("testdata/standard/basic.asdf", nil)
+	req.NoError(err)
+	lazy, err := OpenFileWithOptions("testdata/standard/basic.asdf", OpenOptions{Lazy: true})
+	req.NoError(err)
+	defer lazy.Close()
+	buffer := &bytes.Buffer{}
+	_, err = lazy.WriteTo(buffer)
+	req.NoError(err)
+	copied, err := Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	originalArr := original.Tree.Path("data").Data().(*core.NDArray)
+	copiedArr := copied.Tree.Path("data").Data().(*core.NDArray)
+	req.Equal(originalArr.String(), copiedArr.String())
+	req.Len(copiedArr.Data, 64)
+	req.Equal(originalArr.Data, copiedArr.Data)
+}
+
+func TestCreateStream(t *testing.T) {
+	req := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "go-asdf-")
+	req.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	fileName := filepath.Join(tmpdir, "stream.asdf")
+	stream := &core.NDArray{
+		DataType:  types.Typ[types.Uint16],
+		Shape:     []int{-1, 2, 2},
+		ByteOrder: binary.LittleEndian,
+		Data:      []byte{1, 0, 2, 0, 3, 0, 4, 0},
+	}
+	fixed := &core.NDArray{
+		DataType:  types.Typ[types.Uint8],
+		Shape:     []int{2},
+		ByteOrder: binary.LittleEndian,
+		Data:      []byte{7, 8},
+	}
+	tree := gabs.New()
+	_, err = tree.Set(stream, "frames")
+	req.NoError(err)
+	_, err = tree.Set(fixed, "a_fixed")
+	req.NoError(err)
+	_, err = tree.Set(fixed, "z_fixed")
+	req.NoError(err)
+	writer, err := CreateStream(fileName, &File{Document: core.Document{Tree: tree}})
+	req.NoError(err)
+	req.Equal(1, writer.Rows())
+
+	check := func(rows int) {
+		file, err := OpenFile(fileName, nil)
+		req.NoError(err)
+		arr := file.Tree.Path("frames").Data().(*core.NDArray)
+		req.Equal([]int{rows, 2, 2}, arr.Shape)
+		for i := 0; i < rows*4; i++ {
+			req.Equal(uint16(i+1), binary.LittleEndian.Uint16(arr.Data[i*2:]))
+		}
+		req.Equal([]byte{7, 8}, file.Tree.Path("z_fixed").Data().(*core.NDArray).Data)
+	}
+	check(1)
+	row := make([]byte, 8)
+	for i := 1; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			binary.LittleEndian.PutUint16(row[j*2:], uint16(i*4+j+1))
+		}
+		n, err := writer.Write(row[:3])
+		req.NoError(err)
+		req.Equal(3, n)
+		check(i)
+		_, err = writer.Write(row[3:])
+		req.NoError(err)
+		req.NoError(writer.Sync())
+		check(i + 1)
+	}
+	req.Equal(4, writer.Rows())
+	req.NoError(writer.Close())
+	req.Error(writer.Close())
+	check(4)
+}
+
+func TestCreateStreamIncompleteRow(t *testing.T) {
+	req := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "go-asdf-")
+	req.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	fileName := filepath.Join(tmpdir, "stream.asdf")
+	tree := gabs.New()
+	_, err = CreateStream(fileName, &File{Document: core.Document{Tree: tree}})
+	req.Error(err)
+	_, err = tree.Set(&core.NDArray{
+		DataType: types.Typ[types.Int32], Shape: []int{-1, 2}, ByteOrder: binary.BigEndian,
+	}, "frames")
+	req.NoError(err)
+	writer, err := CreateStream(fileName, &File{Document: core.Document{Tree: tree}})
+	req.NoError(err)
+	req.Equal(0, writer.Rows())
+	_, err = writer.Write(make([]byte, 13))
+	req.NoError(err)
+	req.Equal(1, writer.Rows())
+	req.Error(writer.Close())
+	file, err := OpenFile(fileName, nil)
+	req.NoError(err)
+	req.Equal([]int{1, 2}, file.Tree.Path("frames").Data().(*core.NDArray).Shape)
+	info, err := os.Stat(fileName)
+	req.NoError(err)
+	contents, err := ioutil.ReadFile(fileName)
+	req.NoError(err)
+	req.Equal(int64(bytes.Index(contents, blockMagic[:])+6+blockHeaderSize+8), info.Size())
+}
+
+func TestWriteToTaggedValue(t *testing.T) {
+	req := require.New(t)
+	contents := []byte(`#ASDF 1.0.0
+#ASDF_STANDARD 1.3.0
+%YAML 1.1
+%TAG ! tag:stsci.edu:asdf/
+--- !core/asdf-1.1.0
+unit: !<tag:astropy.org:astropy/units/unit-1.0.0> m
+standard_unit: !unit/unit-1.0.0 m
+wcs: !<tag:stsci.edu:gwcs/wcs-1.0.0>
+  name: ''
+  steps:
+  - !<tag:stsci.edu:gwcs/step-1.0.0> {frame: detector}
+...
+`)
+	file, err := Open(bytes.NewReader(contents), nil)
+	req.NoError(err)
+	unit := file.Tree.Path("unit").Data().(schema.TaggedValue)
+	req.Equal("tag:astropy.org:astropy/units/unit", unit.Tag.Name)
+	req.Equal("m", unit.Node.Value)
+	wcs := file.Tree.Path("wcs").Data().(schema.TaggedValue)
+	req.Equal("tag:stsci.edu:gwcs/wcs-1.0.0", wcs.Tag.String())
+	buffer := &bytes.Buffer{}
+	_, err = file.WriteTo(buffer)
+	req.NoError(err)
+	written := buffer.String()
+	req.Contains(written, "unit: !<tag:astropy.org:astropy/units/unit-1.0.0> m\n")
+	req.Contains(written, "wcs: !<tag:stsci.edu:gwcs/wcs-1.0.0>\n")
+	req.Contains(written, "- !<tag:stsci.edu:gwcs/step-1.0.0> {frame: detector}\n")
+	req.Contains(written, "standard_unit: !unit/unit-1.0.0 m\n")
+	// the tree is not modified
+	standardUnit := file.Tree.Path("standard_unit").Data().(schema.TaggedValue)
+	req.Equal("tag:stsci.edu:asdf/unit/unit-1.0.0", standardUnit.Node.Tag)
+	file, err = Open(bytes.NewReader(buffer.Bytes()), nil)
+	req.NoError(err)
+	req.Equal(unit.Tag, file.Tree.Path("unit").Data().(schema.TaggedValue).Tag)
+	req.Equal(wcs.Tag, file.Tree.Path("wcs").Data().(schema.TaggedValue).Tag)
+	req.Equal(standardUnit.Tag, file.Tree.Path("standard_unit").Data().(schema.TaggedValue).Tag)
+	req.Len(file.Diagnostics, 3)
+}